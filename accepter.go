@@ -4,9 +4,18 @@ package accepter
 import (
 	"context"
 	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
 	"net"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -16,17 +25,457 @@ type Accepter struct {
 	// Handler to invoke.
 	Handler Handler
 
+	// ErrorHandler to invoke instead of Handler, if set.
+	ErrorHandler ErrorHandler
+
+	// OnHandlerError optionally specifies a function that is called when
+	// ErrorHandler or ConfigureConn returns a non-nil error, or when the TLS
+	// handshake fails with an error as TLSError. If nil, the error is logged.
+	OnHandlerError func(conn net.Conn, err error)
+
+	// PacketHandler to invoke for packets on ServePacket.
+	PacketHandler PacketHandler
+
+	// Network and Address specify the network and the address to listen on
+	// when the Accepter is run by RunGroup, e.g. "tcp" and ":8080". Network
+	// defaults to "tcp".
+	Network string
+	Address string
+
+	// ListenConfig provides the options for creating listeners in ListenAndServe
+	// and the other listening methods. Its zero value is valid.
+	ListenConfig net.ListenConfig
+
+	// ReusePort specifies whether SO_REUSEPORT is set on the sockets created by
+	// ListenAndServe and the other listening methods, so that several processes
+	// can listen on the same address. It's supported on Linux and BSD platforms,
+	// otherwise listening fails with ErrReusePortUnsupported.
+	ReusePort bool
+
 	// TLSConfig optionally provides a TLS configuration.
+	// ServeTLS uses a clone of TLSConfig, so the original is never modified.
 	TLSConfig *tls.Config
 
+	// TLSHandshakeTimeout is the maximum duration for the TLS handshake of each
+	// connection served by ServeTLS. When it expires, the connection is closed
+	// without invoking the handler, and the error is passed to OnHandlerError or
+	// logged. Zero or negative values mean no timeout.
+	TLSHandshakeTimeout time.Duration
+
+	// TLSNextProto optionally specifies a function to take over
+	// ownership of the provided TLS connection when an ALPN
+	// protocol upgrade has occurred. The map key is the protocol
+	// name negotiated. The Handler argument should be used to
+	// handle the connection. The connection is closed after the
+	// function returns. ServeTLS adds the keys to the NextProtos
+	// of the TLS configuration.
+	//
+	// The function is given the TLS connection itself, so the
+	// connection wrappers, i.e. CountBytes, the rate limits,
+	// WriteTimeoutPerWrite, IdleTimeout, DetectClose and Peekable,
+	// don't apply to it. IdleTimeout limits only the handshake. The
+	// deadlines of ReadTimeout and WriteTimeout apply.
+	TLSNextProto map[string]func(*Accepter, *tls.Conn, Handler)
+
+	// ConnState specifies an optional callback function that is
+	// called when a connection changes state. See the ConnState
+	// type and associated constants for details.
+	ConnState func(net.Conn, ConnState)
+
+	// BaseContext optionally specifies a function that returns
+	// the base context for incoming connections on this Accepter.
+	// The provided Listener is the specific Listener that's
+	// about to start accepting connections.
+	// If BaseContext is nil, the default is context.Background().
+	// If non-nil, it must return a non-nil context. ServePacket calls it
+	// with a nil Listener.
+	BaseContext func(net.Listener) context.Context
+
+	// ConnContext optionally specifies a function that modifies
+	// the context used for a new connection conn. The provided ctx
+	// is derived from the base context and has a cancellation that
+	// ends with the connection.
+	// If non-nil, it must return a non-nil context.
+	ConnContext func(ctx context.Context, conn net.Conn) context.Context
+
+	// OnServe optionally specifies a function that's called with each Listener
+	// when the Accepter is serving on it, right before its accept loop starts.
+	// So the Listener is ready to accept when it's called, e.g. to connect to the
+	// address of a Listener on an ephemeral port in tests.
+	OnServe func(net.Listener)
+
+	// ErrorLog specifies an optional logger for errors accepting
+	// connections, and unexpected behavior from handlers.
+	// If nil, logging is done via the log package's standard logger
+	// only if DefaultErrorLog is true.
+	ErrorLog *log.Logger
+
+	// DefaultErrorLog specifies whether the log package's standard logger
+	// is used when ErrorLog is nil.
+	DefaultErrorLog bool
+
+	// Logger optionally specifies a logger for the handlers. If set, a logger
+	// prefixing the messages with the connection ID and the remote address is
+	// derived from it for each connection, and it's available to the handler by
+	// ConnLogger. The Accepter itself logs by ErrorLog.
+	Logger Logger
+
+	// PanicHandler optionally specifies a function that is called with the
+	// recovered value when the handler panics. If nil, the panic and its stack
+	// trace are logged. In both cases, the connection is closed afterwards.
+	// For a panic of PacketHandler, it's called with a nil connection.
+	PanicHandler func(conn net.Conn, recovered interface{})
+
+	// MaxConnections limits the number of concurrent connections. When the
+	// limit is reached, new connections are accepted and then closed
+	// immediately. Zero or negative values mean unlimited.
+	MaxConnections int
+
+	// MaxConnsPerIP limits the number of concurrent connections from a single
+	// remote IP address. When the limit is reached for an IP address, new
+	// connections from it are accepted and then closed immediately.
+	// Zero or negative values mean unlimited.
+	MaxConnsPerIP int
+
+	// OnLimitExceeded optionally specifies a function that is called with a
+	// connection rejected due to MaxConnections or MaxConnsPerIP, e.g. to send
+	// a rejection message. It's called in its own goroutine, and the connection
+	// is closed after it returns.
+	OnLimitExceeded func(net.Conn)
+
+	// OnConnClosed optionally specifies a function that is called with each served
+	// connection and the duration since it's accepted, after the connection is
+	// closed or hijacked, e.g. to build latency histograms. It's called before
+	// Shutdown observes the connection has finished. It's called only for the
+	// connections which reached StateNew, so the connections rejected before,
+	// e.g. by AllowCIDRs or MaxConnsPerIP, aren't reported.
+	OnConnClosed func(conn net.Conn, duration time.Duration)
+
+	// AcceptRateLimit optionally limits the rate of accepted connections. By
+	// default, the accept loop waits until the limiter allows the next accept.
+	// The limiter can be reconfigured at runtime.
+	AcceptRateLimit *RateLimiter
+
+	// AcceptRateLimitReject specifies whether connections exceeding
+	// AcceptRateLimit are accepted and then closed immediately, instead of
+	// waiting for the limiter.
+	AcceptRateLimitReject bool
+
+	// SpawnRateLimit optionally limits the rate of the handler goroutines
+	// started by the accept loops, as a last line of defense against resource
+	// exhaustion under an accept flood. Unlike AcceptRateLimit, it only counts
+	// the connections passing the checks in the accept loop, and unlike
+	// Concurrency, it doesn't queue the handlers: the accept loop itself pauses
+	// until the limiter allows the next goroutine. It's ignored if NumWorkers is
+	// positive, since the workers don't spawn goroutines.
+	SpawnRateLimit *RateLimiter
+
+	// AcceptBackoffMin is the initial delay before retrying after a temporary
+	// error of accepting connections or reading packets. The delay doubles on
+	// each consecutive error, and it's reset after a success. Zero or
+	// negative values mean the default of 5ms.
+	AcceptBackoffMin time.Duration
+
+	// AcceptBackoffMax is the maximum delay before retrying after a temporary
+	// error. Zero or negative values mean the default of 1s.
+	AcceptBackoffMax time.Duration
+
+	// Metrics optionally specifies the instrumentation of the Accepter. If nil,
+	// no instrumentation is done other than Stats.
+	Metrics Metrics
+
+	// OnAccept optionally specifies a function that is called right after a
+	// connection is accepted. If it returns false, the connection is closed
+	// without being tracked or served. It's called in the accept loop, so it
+	// shouldn't block. Note that RemoteAddr and LocalAddr read the header when
+	// ProxyProtocol is set.
+	OnAccept func(conn net.Conn) bool
+
+	// ConnWrapper optionally specifies a function that wraps each accepted
+	// connection, e.g. for instrumentation or compression. It's called after
+	// OnAccept, and the returned connection is the one tracked, passed to the
+	// handler and closed on Shutdown or Close. If it returns nil, the connection
+	// is closed without being served. Since the TCP options and the TLS handshake
+	// are applied on the underlying *net.TCPConn or *tls.Conn, they're skipped if
+	// the wrapper hides them.
+	ConnWrapper func(net.Conn) net.Conn
+
+	// IdleTimeout is the maximum amount of time to wait for the next read or
+	// write on a connection. When set, the connection passed to the handler is
+	// wrapped to extend its deadline by IdleTimeout after each successful read
+	// or write, so blocking I/O fails with a timeout error on an idle connection.
+	// Since the wrapper overwrites the deadlines, deadlines set by the handler
+	// only hold until the next successful read or write. The extended deadlines
+	// never exceed the ones given by ReadTimeout and WriteTimeout.
+	// Zero or negative values mean no timeout.
+	IdleTimeout time.Duration
+
+	// IdleTimeoutOverridable specifies whether the handler can disable
+	// IdleTimeout on a connection by setting its own deadline. When set, the
+	// wrapper stops extending the deadlines once the handler calls SetDeadline,
+	// SetReadDeadline or SetWriteDeadline, so the handler's deadlines hold.
+	IdleTimeoutOverridable bool
+
+	// ReadTimeout is the maximum duration for reading from a connection since
+	// it's accepted. It's applied by setting the read deadline before invoking
+	// the handler, and the handler can still override it by setting its own
+	// deadline. Zero or negative values mean no timeout.
+	ReadTimeout time.Duration
+
+	// WriteTimeout is the maximum duration for writing to a connection since
+	// it's accepted. It's applied by setting the write deadline before invoking
+	// the handler, and the handler can still override it by setting its own
+	// deadline. Zero or negative values mean no timeout.
+	WriteTimeout time.Duration
+
+	// WriteTimeoutPerWrite specifies whether WriteTimeout is refreshed per write.
+	// When set, the connection passed to the handler is wrapped to set the write
+	// deadline to WriteTimeout from now before each write, instead of setting it
+	// once on accept. So a slow or stuck reader fails a write rather than
+	// blocking it forever. The refreshed deadline overwrites the write deadlines
+	// set by the handler or IdleTimeout.
+	WriteTimeoutPerWrite bool
+
+	// KeepAlive specifies whether TCP keep-alives are enabled on accepted TCP
+	// connections. If false, the keep-alive setting of the listener is left as is.
+	// It has no effect on other connection types, including TLS connections.
+	KeepAlive bool
+
+	// KeepAlivePeriod specifies the period between TCP keep-alives when
+	// KeepAlive is true. Zero or negative values mean the default of the
+	// operating system.
+	KeepAlivePeriod time.Duration
+
+	// NoDelay specifies whether Nagle's algorithm is disabled on accepted TCP
+	// connections, by SetNoDelay. It also applies to the TCP connections under
+	// TLS. If nil, the setting of the operating system is left as is. It has no
+	// effect on other connection types.
+	NoDelay *bool
+
+	// Linger specifies the SO_LINGER behavior of accepted TCP connections, by
+	// SetLinger. Zero discards unsent data and resets the connection on close,
+	// positive values wait for unsent data to be sent for that many seconds, and
+	// negative values send the data in the background. It also applies to the
+	// connections forcibly closed by Shutdown or Close. If nil, the setting of
+	// the operating system is left as is. Like NoDelay, it also applies under
+	// TLS, and it has no effect on other connection types.
+	Linger *int
+
+	// ConfigureConn optionally specifies a function that is called with each
+	// accepted connection before invoking the handler, e.g. to set socket
+	// options or to validate the connection in one place. It's called after the
+	// TCP options above are applied, with the connection as accepted. If it
+	// returns an error, the error is passed to OnHandlerError or logged, and
+	// the connection is closed without invoking the handler.
+	ConfigureConn func(net.Conn) error
+
+	// DetectClose specifies whether the connection context is cancelled as
+	// soon as the peer closes the connection, even if the handler isn't
+	// reading. When set, the connection passed to the handler is wrapped to
+	// read ahead in a background goroutine, so it costs an extra goroutine,
+	// a buffer and a copy on every read for each connection. Since reading
+	// ahead can't detect closing while unread data is pending, it's detected
+	// after the handler consumes such data.
+	DetectClose bool
+
+	// BufferPool optionally specifies a pool to take the buffers read ahead by
+	// DetectClose from, to reduce the allocations. It may be shared with the
+	// handlers, e.g. by CopyBuffer. If nil, a new buffer is allocated for each
+	// read.
+	BufferPool BufferPool
+
+	// Concurrency limits the number of handlers running simultaneously. The
+	// connections beyond the limit wait in a queue until a handler returns,
+	// or until QueueTimeout expires. The queued connections are closed on
+	// Shutdown or Close. Zero or negative values mean unlimited.
+	Concurrency int
+
+	// NumWorkers is the number of worker goroutines serving the connections
+	// accepted by Serve, ServeTLS or ServeMany. If positive, the accept loops
+	// push the connections to a queue of NumWorkers length, and the accept
+	// loops block while the queue is full. So the number of goroutines is
+	// bounded, and busy workers give backpressure to accepting. The workers stop
+	// after serving the queued connections when serving stops. Zero or negative
+	// values mean a new goroutine for each connection.
+	NumWorkers int
+
+	// QueueTimeout is the maximum duration for a connection to wait in the
+	// queue when Concurrency is reached. When it expires, the connection is
+	// closed. Zero or negative values mean no timeout.
+	QueueTimeout time.Duration
+
+	// MaxConnLifetime is the maximum duration for a connection to stay open,
+	// regardless of activity. When it expires, the connection context is
+	// cancelled and the connection is closed. Zero or negative values mean
+	// unlimited.
+	MaxConnLifetime time.Duration
+
+	// HandlerTimeout is the maximum duration for the handler to serve a
+	// connection, regardless of activity. The context passed to the handler has
+	// a deadline by HandlerTimeout, and when it expires, the connection is closed
+	// so the handler unblocks. Zero or negative values mean no timeout.
+	HandlerTimeout time.Duration
+
+	// DrainTimeout is the maximum duration for each connection to exit the
+	// handler on Shutdown, after the handler contexts are cancelled. The
+	// connections that are still being served after DrainTimeout are closed,
+	// independently of the context given to Shutdown, and then Shutdown keeps
+	// waiting for their handlers to return. Zero or negative values mean no
+	// timeout.
+	DrainTimeout time.Duration
+
+	// MaxLifetimeConns is the maximum number of connections to serve on Serve,
+	// ServeTLS or ServeMany. Once that many connections have been accepted,
+	// the Listeners are closed without cancelling the connection contexts,
+	// then Serve returns ErrServerClosed after the connections are finished.
+	// Zero means unlimited.
+	MaxLifetimeConns uint64
+
+	// AllowCIDRs and DenyCIDRs filter the accepted connections by the remote IP
+	// address. The connections matching any of DenyCIDRs, or not matching any of
+	// AllowCIDRs if it isn't empty, are closed before invoking the handler. Deny
+	// takes precedence over allow. The entries are CIDRs like "10.0.0.0/8" or
+	// single IP addresses, and they're parsed when serving starts; Serve returns
+	// an error wrapping ErrInvalidOption for a malformed entry. Connections
+	// without a remote IP address, e.g. on Unix domain sockets, aren't filtered.
+	AllowCIDRs []string
+	DenyCIDRs  []string
+
+	// AcceptTimeout is the maximum duration to wait for the next connection on
+	// Serve, ServeTLS or ServeMany. On ServeMany, it expires only when none of
+	// the Listeners has accepted a connection for that duration. When it
+	// expires, accepting stops and the connection contexts are cancelled like
+	// on Shutdown, and Serve returns ErrServerClosed without waiting for the
+	// connections, e.g. to avoid hanging tests.
+	// It's applied by setting the deadline before each accept, so it's ignored
+	// for the Listeners without a SetDeadline method, like the custom ones.
+	// Zero or negative values mean no timeout.
+	AcceptTimeout time.Duration
+
+	// ShutdownTimeout is the maximum duration for the Shutdown called by
+	// ListenAndServeContext and ListenAndServeWithSignals. Zero or negative values
+	// mean no timeout.
+	ShutdownTimeout time.Duration
+
+	// ReadBytesPerSec limits the read rate of each connection in bytes per
+	// second, with bursts of at most one second worth of bytes. When set, the
+	// connection passed to the handler is wrapped to split large reads and to
+	// sleep after each read until it's within the limit. The sleeps are
+	// interrupted when the connection context is done. Zero or negative values
+	// mean unlimited.
+	ReadBytesPerSec int
+
+	// ReadRateLimiter optionally limits the total read rate of all connections,
+	// consuming a token for each byte read. It's shared by the connections, and
+	// it may be combined with ReadBytesPerSec.
+	ReadRateLimiter *RateLimiter
+
+	// WriteBytesPerSec limits the write rate of each connection in bytes per
+	// second, with bursts of at most one second worth of bytes. When set, the
+	// connection passed to the handler is wrapped to split large writes and to
+	// sleep before each chunk until it's within the limit. Writes aren't
+	// dropped, but the sleeps are interrupted when the connection context is
+	// done, e.g. on Shutdown, and then Write returns the context error. Zero or
+	// negative values mean unlimited.
+	WriteBytesPerSec int
+
+	// WriteRateLimiter optionally limits the total write rate of all
+	// connections, consuming a token for each byte written. It's shared by the
+	// connections, and it may be combined with WriteBytesPerSec.
+	WriteRateLimiter *RateLimiter
+
+	// CountBytes specifies whether the bytes read from and written to each
+	// connection are counted. When set, the connection passed to the handler
+	// is wrapped to count the bytes. The counts of a connection are available
+	// to the handler by ConnBytes, and the totals by Stats.
+	CountBytes bool
+
+	// Peekable specifies whether the connection passed to the handler is
+	// wrapped in a buffered reader, so it implements PeekableConn. It enables
+	// the handler to sniff the first bytes before deciding how to handle the
+	// connection.
+	Peekable bool
+
+	// ProfileLabels specifies whether the handler goroutines are labelled with
+	// "accepter.conn_id" and "accepter.remote_addr" by runtime/pprof, so they
+	// can be told apart in goroutine dumps and CPU profiles. The goroutines
+	// started by the handler with the context get the labels too. It's off by
+	// default due to the small overhead.
+	ProfileLabels bool
+
+	// UnixSocketMode optionally specifies the file permissions of the socket file
+	// created by UnixListenAndServe. Zero means the default of the operating system.
+	UnixSocketMode os.FileMode
+
+	// ProxyProtocol specifies whether accepted connections start with a PROXY
+	// protocol v1 or v2 header, e.g. behind a load balancer. When set, the
+	// RemoteAddr and LocalAddr methods of connections return the addresses in
+	// the header. Connections with a malformed header are closed, and reading
+	// from them returns ErrInvalidProxyHeader. If AllowCIDRs, DenyCIDRs or
+	// MaxConnsPerIP is set, the header is read before invoking the handler,
+	// limited by ReadTimeout or IdleTimeout, whichever is shorter.
+	ProxyProtocol bool
+
+	// ListenerWrapper optionally specifies a function that wraps each Listener
+	// served by Serve, ServeTLS or ServeMany, e.g. to measure accepting. It's
+	// applied after the PROXY protocol wrapping, and before the TLS wrapping on
+	// ServeTLS. The wrapped Listener is the one accepted on, closed by Shutdown
+	// or Close, and whose address is returned by Addr.
+	ListenerWrapper func(net.Listener) net.Listener
+
+	// mu guards the listeners, the PacketConn and the serving context, which are set by
+	// start and read by the shutdown methods and Addr.
 	mu           sync.RWMutex
-	lis          net.Listener
+	listeners    []net.Listener
+	rawListeners []net.Listener
+	pc           net.PacketConn
 	lisCloseOnce *sync.Once
 	lisCloseErr  error
 	ctx          context.Context
 	ctxCancel    context.CancelFunc
-	conns        map[net.Conn]struct{}
+	onShutdown   []func()
+	inShutdown   bool
+	conns        map[net.Conn]*connData
 	connsMu      sync.RWMutex
+	connsDone    chan struct{}
+	sem          chan struct{}
+	queue        chan net.Conn
+	stopped      bool
+	done         chan struct{}
+	errs         chan error
+	doneClosed   bool
+	packets      int
+	ipConns      map[string]int
+	ipFilter     *ipFilter
+	lastConnID   atomic.Uint64
+	servedConns  atomic.Uint64
+	lastAccept   atomic.Int64
+	stopAccept   atomic.Bool
+	lisStopped   atomic.Bool
+	closing      atomic.Bool
+	preShutdown  atomic.Bool
+	stats        stats
+}
+
+// connData holds the data of a tracked connection.
+type connData struct {
+	id         uint64
+	startedAt  time.Time
+	cancel     context.CancelFunc
+	remoteAddr net.Addr
+	localAddr  net.Addr
+	bytes      *byteCounter
+	opened     bool
+}
+
+// close cancels the connection context if it's set, and then closes conn. So the handler
+// observes the cancellation before the closing.
+// It must be called with a.connsMu locked.
+func (cd *connData) close(conn net.Conn) {
+	if cd.cancel != nil {
+		cd.cancel()
+	}
+	conn.Close()
 }
 
 var (
@@ -39,77 +488,300 @@ func SetMaxTempDelay(d time.Duration) {
 	atomic.StoreInt64((*int64)(&maxTempDelay), int64(d))
 }
 
-// cancel cancels serving operation and closes listener once, then returns closing error.
+// tempDelay holds the waiting state for consecutive temporary errors.
+type tempDelay struct {
+	min   time.Duration
+	max   time.Duration
+	delay time.Duration
+	total time.Duration
+}
+
+// newTempDelay returns a new tempDelay with the backoff options of the Accepter.
+func (a *Accepter) newTempDelay() *tempDelay {
+	d := &tempDelay{
+		min: a.AcceptBackoffMin,
+		max: a.AcceptBackoffMax,
+	}
+	if d.min <= 0 {
+		d.min = 5 * time.Millisecond
+	}
+	if d.max <= 0 {
+		d.max = 1 * time.Second
+	}
+	return d
+}
+
+// next returns the next waiting duration and adds it to the total. If exhausted is true,
+// the waiting duration is at least 100ms to back off more aggressively. It returns false
+// if the total exceeds the maximum temporary delay.
+func (d *tempDelay) next(exhausted bool) (time.Duration, bool) {
+	maxDelay := time.Duration(atomic.LoadInt64((*int64)(&maxTempDelay)))
+	if maxDelay > 0 && d.total > maxDelay {
+		return maxDelay, false
+	}
+	if d.delay == 0 {
+		d.delay = d.min
+	} else {
+		d.delay *= 2
+	}
+	if min := 100 * time.Millisecond; exhausted && d.delay < min {
+		d.delay = min
+	}
+	if d.delay > d.max {
+		d.delay = d.max
+	}
+	d.total += d.delay
+	return d.delay, true
+}
+
+// reset resets the waiting state after a successful operation.
+func (d *tempDelay) reset() {
+	d.delay = 0
+	d.total = 0
+}
+
+// sleep pauses for the duration d, or until ctx is done. It returns false if ctx is done
+// before d elapses.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// cancel closes listener once and then cancels serving operation, then returns closing
+// error. Since accepting stops before the contexts are cancelled, no connection accepted
+// after cancel is called is handled. It's safe to call cancel repeatedly and concurrently,
+// every call returns the result of the first closing. A Listener already closed elsewhere
+// isn't treated as an error. It returns ErrNotServing if the Accepter has never served,
+// and then the next start returns ErrServerClosed.
 func (a *Accepter) cancel() error {
 	a.mu.RLock()
 	defer a.mu.RUnlock()
-	if a.lis == nil {
-		return nil
+	if a.listeners == nil && a.pc == nil {
+		a.preShutdown.Store(true)
+		return ErrNotServing
 	}
+	a.closing.Store(true)
+	err := a.closeListeners()
 	a.ctxCancel()
+	return err
+}
+
+// closeListeners closes the Listeners or the PacketConn once, and returns the closing error.
+// It must be called with a.mu locked while serving.
+func (a *Accepter) closeListeners() error {
 	a.lisCloseOnce.Do(func() {
-		a.lisCloseErr = a.lis.Close()
+		for _, lis := range a.listeners {
+			if err := lis.Close(); err != nil && !errors.Is(err, net.ErrClosed) && a.lisCloseErr == nil {
+				a.lisCloseErr = err
+			}
+		}
+		if a.pc != nil {
+			if err := a.pc.Close(); err != nil && !errors.Is(err, net.ErrClosed) && a.lisCloseErr == nil {
+				a.lisCloseErr = err
+			}
+		}
 	})
 	return a.lisCloseErr
 }
 
+// stopListeners closes the Listeners without cancelling the connection contexts, and makes
+// the accept loops drop the connections accepted after it.
+func (a *Accepter) stopListeners() {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	a.closing.Store(true)
+	a.closeListeners()
+}
+
+// StopListener closes the Accepter's underlying Listeners without cancelling the
+// connection contexts, and returns immediately. So the port is freed, and the connections
+// being served run to completion. Serve returns ErrServerClosed without waiting for them,
+// and a later Shutdown cancels the connection contexts and drains them. StopListener
+// returns ErrNotServing if the Accepter isn't serving on a Listener.
+func (a *Accepter) StopListener() error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.listeners == nil {
+		return ErrNotServing
+	}
+	a.lisStopped.Store(true)
+	return a.closeListeners()
+}
+
 // Shutdown gracefully shuts down the Accepter without interrupting any
 // connections. Shutdown works by first closing the Accepter's underlying Listener, then
 // cancels the context on Serve method of Handler, and then waiting indefinitely for
-// connections to exit Serve method of Handler and then close. If the provided
-// context expires before the shutdown is complete, Shutdown returns the
-// context's error, otherwise it returns any error returned from closing the
-// Accepter's underlying Listener.
+// connections to exit Serve method of Handler and then close. This ordering is
+// guaranteed, so no connection accepted after Shutdown begins is handled. If the provided
+// context expires before the shutdown is complete, Shutdown forcibly closes the
+// remaining connections.
+//
+// The error returned by Shutdown is chosen in the following precedence:
+//   - ErrNotServing if the Accepter has never served.
+//   - An error wrapping both ErrShutdownTimeout and the context's error if the context
+//     expired before the connections are drained, even if closing the Listener failed
+//     too. So errors.Is(err, ErrShutdownTimeout) reports whether the drain was cut
+//     short by the context, while ShutdownWithStats also counts the connections closed
+//     by DrainTimeout.
+//   - Any error returned from closing the Accepter's underlying Listener, except
+//     net.ErrClosed, e.g. when the Listener has already been closed by a racing Close
+//     or elsewhere.
+//   - Otherwise nil.
+//
+// Shutdown and Close are idempotent and safe to call concurrently, e.g. from both a
+// signal handler and a context. Only the first call closes the Listener, and the
+// later calls return the same closing error. The functions registered by
+// RegisterOnShutdown are called once.
 //
 // When Shutdown is called, Serve, ServeTLS, ListenAndServe, and ListenAndServeTLS
-// immediately return nil. Make sure the program doesn't exit and waits
-// instead for Shutdown to return.
-func (a *Accepter) Shutdown(ctx context.Context) (err error) {
+// immediately return ErrServerClosed. Make sure the program doesn't exit and waits
+// instead for Shutdown to return. If Shutdown or Close is called before the Accepter
+// has ever served, e.g. while Serve is starting in another goroutine, it's remembered,
+// and the next serving method returns ErrServerClosed immediately.
+func (a *Accepter) Shutdown(ctx context.Context) error {
+	_, err := a.ShutdownWithStats(ctx)
+	return err
+}
+
+// ShutdownWithStats is similar to Shutdown, but also returns the number of the connections
+// forcibly closed due to the expiration of ctx or DrainTimeout.
+func (a *Accepter) ShutdownWithStats(ctx context.Context) (forced int, err error) {
 	err = a.cancel()
+	if err == ErrNotServing {
+		return
+	}
 
+	a.mu.Lock()
+	a.inShutdown = true
+	for _, f := range a.onShutdown {
+		go f()
+	}
+	a.onShutdown = nil
+	a.mu.Unlock()
+
+	connsDone := a.drained()
+	if connsDone == nil {
+		return
+	}
+
+	var drain <-chan time.Time
+	if a.DrainTimeout > 0 {
+		t := time.NewTimer(a.DrainTimeout)
+		defer t.Stop()
+		drain = t.C
+	}
+
+	closed := make(map[net.Conn]struct{})
 	for {
 		select {
-		case <-time.After(5 * time.Millisecond):
-			a.connsMu.RLock()
-			if len(a.conns) == 0 {
-				a.connsMu.RUnlock()
-				return
-			}
-			a.connsMu.RUnlock()
+		case <-connsDone:
+			return
+		case <-drain:
+			forced += a.closeConns(closed)
+			drain = nil
 		case <-ctx.Done():
-			a.connsMu.RLock()
-			for conn := range a.conns {
-				conn.Close()
-			}
-			a.connsMu.RUnlock()
-			err = ctx.Err()
+			forced += a.closeConns(closed)
+			err = fmt.Errorf("%w: %w", ErrShutdownTimeout, ctx.Err())
 			return
 		}
 	}
 }
 
+// closeConns closes the tracked connections except the ones in closed, and adds them to
+// closed. It returns the number of the connections closed.
+func (a *Accepter) closeConns(closed map[net.Conn]struct{}) (n int) {
+	a.connsMu.RLock()
+	defer a.connsMu.RUnlock()
+	for conn, cd := range a.conns {
+		if _, ok := closed[conn]; ok {
+			continue
+		}
+		cd.close(conn)
+		closed[conn] = struct{}{}
+		n++
+	}
+	return
+}
+
+// StopAccepting makes the Accepter close the new connections right after accepting them,
+// without closing the Listeners. Serve keeps running, and the connections being served
+// aren't affected. It's the first phase of a two-phase shutdown, e.g. to let a standby
+// process listening with ReusePort take over, and a later Shutdown closes the Listeners
+// and drains the connections. StopAccepting returns ErrNotServing if the Accepter isn't
+// serving on a Listener.
+func (a *Accepter) StopAccepting() error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.listeners == nil {
+		return ErrNotServing
+	}
+	a.stopAccept.Store(true)
+	return nil
+}
+
+// RegisterOnShutdown registers a function to call on Shutdown. It's called in its own
+// goroutine after the Accepter's underlying Listener is closed, before waiting for
+// connections. If Shutdown has already started, f is called immediately.
+func (a *Accepter) RegisterOnShutdown(f func()) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.inShutdown {
+		go f()
+		return
+	}
+	a.onShutdown = append(a.onShutdown, f)
+}
+
 // Close immediately closes the Accepter's underlying Listener and any connections.
-// For a graceful shutdown, use Shutdown.
+// For a graceful shutdown, use Shutdown. The context of each connection is cancelled
+// before closing the connection, as well as on the forced closing by Shutdown, so
+// the handlers waiting on the context wake up first.
 //
 // Close returns any error returned from closing the Accepter's underlying
-// Listener.
+// Listener, except net.ErrClosed. Close returns ErrNotServing if the Accepter has
+// never served. Close may be called repeatedly, or after Shutdown.
 func (a *Accepter) Close() (err error) {
 	err = a.cancel()
+	if err == ErrNotServing {
+		return
+	}
 
 	a.connsMu.RLock()
-	for conn := range a.conns {
-		conn.Close()
+	for conn, cd := range a.conns {
+		cd.close(conn)
 	}
 	a.connsMu.RUnlock()
 
 	return
 }
 
+// Addr returns the address of the Accepter's underlying Listener or PacketConn,
+// or nil if the Accepter has never served. When serving by ServeMany, it returns
+// the address of the first Listener.
+func (a *Accepter) Addr() net.Addr {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	switch {
+	case a.listeners != nil:
+		return a.listeners[0].Addr()
+	case a.pc != nil:
+		return a.pc.LocalAddr()
+	default:
+		return nil
+	}
+}
+
 // ListenAndServe listens on the given network and address; and then calls
-// Serve to handle incoming connections. ListenAndServe returns a
-// nil error after Close or Shutdown method called.
+// Serve to handle incoming connections. ListenAndServe returns
+// ErrServerClosed after Close or Shutdown method called.
 func (a *Accepter) ListenAndServe(network, address string) error {
-	lis, err := net.Listen(network, address)
+	lis, err := a.listenConfig().Listen(context.Background(), network, address)
 	if err != nil {
 		return err
 	}
@@ -117,17 +789,82 @@ func (a *Accepter) ListenAndServe(network, address string) error {
 	return a.Serve(lis)
 }
 
+// TCP4ListenAndServe is similar to ListenAndServe, but listens on the given address
+// only for IPv4, with the "tcp4" network.
+func (a *Accepter) TCP4ListenAndServe(address string) error {
+	return a.ListenAndServe("tcp4", address)
+}
+
+// TCP6ListenAndServe is similar to ListenAndServe, but listens on the given address
+// only for IPv6, with the "tcp6" network. On some platforms, a wildcard address
+// still accepts IPv4-mapped connections, depending on the IPV6_V6ONLY default.
+func (a *Accepter) TCP6ListenAndServe(address string) error {
+	return a.ListenAndServe("tcp6", address)
+}
+
+// ListenAndServeContext listens on the given network and address with ctx; and then calls
+// Serve to handle incoming connections. When ctx is done, it calls Shutdown, and returns
+// after the connections are drained or ShutdownTimeout expired. ListenAndServeContext
+// returns the error of Shutdown if it failed, otherwise the error of Serve.
+func (a *Accepter) ListenAndServeContext(ctx context.Context, network, address string) error {
+	lis, err := a.listenConfig().Listen(ctx, network, address)
+	if err != nil {
+		return err
+	}
+	defer lis.Close()
+
+	started := make(chan struct{})
+	stop := make(chan struct{})
+	shutdownErr := make(chan error, 1)
+	go func() {
+		// Shutdown is called only after serving has started, so a cancellation
+		// before isn't lost.
+		select {
+		case <-started:
+		case <-stop:
+			shutdownErr <- nil
+			return
+		}
+		select {
+		case <-ctx.Done():
+			shutdownErr <- a.shutdownWithTimeout()
+		case <-stop:
+			shutdownErr <- nil
+		}
+	}()
+
+	err = a.serveListeners([]net.Listener{lis}, a.wrapListener, func() {
+		close(started)
+	})
+	close(stop)
+	if e := <-shutdownErr; e != nil && err == ErrServerClosed {
+		err = e
+	}
+	return err
+}
+
+// shutdownWithTimeout calls Shutdown with a context limited by ShutdownTimeout.
+func (a *Accepter) shutdownWithTimeout() error {
+	ctx := context.Background()
+	if a.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.ShutdownTimeout)
+		defer cancel()
+	}
+	return a.Shutdown(ctx)
+}
+
 // ListenAndServeTLS listens on the given network and address; and
 // then calls ServeTLS to handle incoming TLS connections.
 //
 // Filenames containing a certificate and matching private key for the
-// Accepter must be provided if neither the Accepter's TLSConfig.Certificates
-// nor TLSConfig.GetCertificate are populated. If the certificate is
-// signed by a certificate authority, the certFile should be the
+// Accepter must be provided if none of the Accepter's TLSConfig.Certificates,
+// TLSConfig.GetCertificate and TLSConfig.GetConfigForClient are populated.
+// If the certificate is signed by a certificate authority, the certFile should be the
 // concatenation of the Accepter's certificate, any intermediates, and
 // the CA's certificate.
 func (a *Accepter) ListenAndServeTLS(network, address string, certFile, keyFile string) error {
-	lis, err := net.Listen(network, address)
+	lis, err := a.listenConfig().Listen(context.Background(), network, address)
 	if err != nil {
 		return err
 	}
@@ -138,82 +875,364 @@ func (a *Accepter) ListenAndServeTLS(network, address string, certFile, keyFile
 // Serve accepts incoming connections on the Listener lis, creating a new service
 // goroutine for each. The service goroutines read requests and then call
 // a.Handler to reply to them. Serve always closes lis unless returned error
-// is ErrAlreadyServed. Serve returns a nil error after Close or
-// Shutdown method called.
-func (a *Accepter) Serve(lis net.Listener) (err error) {
+// is ErrAlreadyServed. Serve returns ErrServerClosed after Close or
+// Shutdown method called. If accepting fails, Serve returns the error wrapped
+// with the address of lis and the number of the temporary errors before.
+//
+// The Accepter may serve again after Serve returned and all of the connections
+// have finished, e.g. after Shutdown returned without error. Serve returns
+// ErrAlreadyServed while the Accepter is still serving.
+func (a *Accepter) Serve(lis net.Listener) error {
+	return a.serveListeners([]net.Listener{lis}, a.wrapListener, nil)
+}
+
+// ServeMany accepts incoming connections on all of the Listeners listeners like
+// Serve, with an accept loop for each. The connections share the Handler and the
+// connection tracking, and Shutdown or Close affects all of the listeners.
+// If BaseContext is set, it's called with the first Listener. If an accept loop
+// fails, all of the listeners are closed. ServeMany returns the errors of the
+// failed accept loops joined, ErrServerClosed after Close or Shutdown method called,
+// or ErrAlreadyServed.
+func (a *Accepter) ServeMany(listeners ...net.Listener) error {
+	if len(listeners) == 0 {
+		return nil
+	}
+	return a.serveListeners(listeners, a.wrapListener, nil)
+}
+
+// serveListeners is the implementation of Serve and ServeMany. It accepts on the
+// Listeners raw wrapped by wrap. If started isn't nil, it's called once serving has
+// started, so Shutdown and Close called after it take effect.
+func (a *Accepter) serveListeners(raw []net.Listener, wrap func(net.Listener) net.Listener, started func()) error {
+	listeners := make([]net.Listener, 0, len(raw))
+	for _, lis := range raw {
+		listeners = append(listeners, wrap(lis))
+	}
+
+	baseCtx := context.Background()
+	if a.BaseContext != nil {
+		baseCtx = a.BaseContext(listeners[0])
+		if baseCtx == nil {
+			panic("BaseContext returned a nil context")
+		}
+	}
+
+	if err := a.start(baseCtx, raw, listeners, nil); err != nil {
+		if err != ErrAlreadyServed {
+			for _, lis := range listeners {
+				lis.Close()
+			}
+		}
+		return err
+	}
+
+	defer a.stop()
+	defer func() {
+		if !a.lisStopped.Load() {
+			a.cancel()
+		}
+	}()
+	if started != nil {
+		started()
+	}
+
+	if a.queue != nil {
+		queue := a.queue
+		defer close(queue)
+		for i := 0; i < a.NumWorkers; i++ {
+			go a.worker(queue)
+		}
+	}
+
+	if a.OnServe != nil {
+		for _, lis := range listeners {
+			a.OnServe(lis)
+		}
+	}
+
+	err := a.acceptLoops(listeners, raw)
+	if a.MaxLifetimeConns > 0 && a.servedConns.Load() >= a.MaxLifetimeConns {
+		if connsDone := a.drained(); connsDone != nil {
+			<-connsDone
+		}
+	}
+	return err
+}
+
+// acceptLoops runs acceptLoop for each of the Listeners listeners with the unwrapped ones in
+// raw, and returns their errors joined, or ErrServerClosed.
+func (a *Accepter) acceptLoops(listeners, raw []net.Listener) error {
+	if len(listeners) == 1 {
+		return a.acceptLoop(listeners[0], raw[0])
+	}
+
+	errs := make([]error, len(listeners))
+	var wg sync.WaitGroup
+	for i, lis := range listeners {
+		wg.Add(1)
+		go func(i int, lis net.Listener) {
+			defer wg.Done()
+			if err := a.acceptLoop(lis, raw[i]); err != ErrServerClosed {
+				errs[i] = err
+				a.cancel()
+			}
+		}(i, lis)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	return ErrServerClosed
+}
+
+// start initializes the Accepter to serve on the Listeners listeners or the PacketConn pc.
+// If the Accepter has served before, it's reset if the serving has stopped and all of the
+// connections and packet handlers have finished. Otherwise start returns ErrAlreadyServed.
+// If Shutdown or Close has been called before the Accepter has ever served, start returns
+// ErrServerClosed once.
+// It returns an error, and leaves the Accepter as is, if AllowCIDRs or DenyCIDRs is invalid.
+func (a *Accepter) start(baseCtx context.Context, raw, listeners []net.Listener, pc net.PacketConn) error {
 	a.mu.Lock()
-	if a.lis != nil {
-		err = ErrAlreadyServed
-		a.mu.Unlock()
-		return
+	defer a.mu.Unlock()
+	a.connsMu.Lock()
+	defer a.connsMu.Unlock()
+	served := a.listeners != nil || a.pc != nil
+	if served && (!a.stopped || len(a.conns) != 0 || a.packets != 0) {
+		return ErrAlreadyServed
 	}
-	a.lis = lis
+	if a.preShutdown.Swap(false) {
+		return ErrServerClosed
+	}
+	f, err := newIPFilter(a.AllowCIDRs, a.DenyCIDRs)
+	if err != nil {
+		return err
+	}
+	if served {
+		a.inShutdown = false
+		a.servedConns.Store(0)
+		a.stopAccept.Store(false)
+		a.lisStopped.Store(false)
+		a.closing.Store(false)
+		a.lisCloseErr = nil
+		a.stopped = false
+		if a.doneClosed {
+			a.done = nil
+			a.doneClosed = false
+		}
+	}
+	a.ipFilter = f
+	a.listeners = listeners
+	a.rawListeners = raw
+	a.pc = pc
 	a.lisCloseOnce = new(sync.Once)
-	a.ctx, a.ctxCancel = context.WithCancel(context.Background())
-	a.mu.Unlock()
+	a.lastAccept.Store(time.Now().UnixNano())
+	a.ctx, a.ctxCancel = context.WithCancel(baseCtx)
+	a.conns = make(map[net.Conn]*connData)
+	a.sem = nil
+	if a.Concurrency > 0 {
+		a.sem = make(chan struct{}, a.Concurrency)
+	}
+	a.queue = nil
+	if a.NumWorkers > 0 && listeners != nil {
+		a.queue = make(chan net.Conn, a.NumWorkers)
+	}
+	return nil
+}
 
-	a.connsMu.Lock()
-	a.conns = make(map[net.Conn]struct{})
-	a.connsMu.Unlock()
+// dispatch serves the tracked connection conn in a new goroutine, or pushes it to the queue
+// of the workers if NumWorkers is positive. If the Accepter is cancelled while waiting for
+// SpawnRateLimit or the queue, conn is closed without serving.
+func (a *Accepter) dispatch(conn net.Conn) {
+	if a.queue == nil {
+		if l := a.SpawnRateLimit; l != nil && l.Wait(a.ctx) != nil {
+			conn.Close()
+			a.untrack(conn)
+			return
+		}
+		go a.serve(a.ctx, conn)
+		return
+	}
+	select {
+	case a.queue <- conn:
+	case <-a.ctx.Done():
+		conn.Close()
+		a.untrack(conn)
+	}
+}
+
+// worker serves the connections in queue until it's closed.
+func (a *Accepter) worker(queue <-chan net.Conn) {
+	for conn := range queue {
+		a.serve(a.ctx, conn)
+	}
+}
 
-	defer a.cancel()
+// acceptDeadline returns the time when AcceptTimeout expires since the last connection
+// accepted on any of the Listeners.
+func (a *Accepter) acceptDeadline() time.Time {
+	return time.Unix(0, a.lastAccept.Load()).Add(a.AcceptTimeout)
+}
 
-	var tempDelay, totalDelay time.Duration
+// acceptLoop accepts incoming connections on lis until the Accepter is cancelled or
+// accepting fails. The error of accepting is wrapped with the address of lis and the number
+// of the temporary errors before. The accept deadline by AcceptTimeout is set on raw, which
+// is lis unwrapped.
+func (a *Accepter) acceptLoop(lis, raw net.Listener) (err error) {
+	dl, _ := raw.(interface {
+		SetDeadline(t time.Time) error
+	})
+	if a.AcceptTimeout <= 0 {
+		dl = nil
+	}
+	td := a.newTempDelay()
+	temps := 0
+	defer func() {
+		if err != nil && err != ErrServerClosed {
+			err = fmt.Errorf("accept on %v failed after %d temporary errors: %w", lis.Addr(), temps, err)
+		}
+	}()
 	for {
+		if l := a.AcceptRateLimit; l != nil && !a.AcceptRateLimitReject {
+			if l.Wait(a.ctx) != nil {
+				return ErrServerClosed
+			}
+		}
+		if dl != nil {
+			dl.SetDeadline(a.acceptDeadline())
+		}
 		var conn net.Conn
 		conn, err = lis.Accept()
 		if err != nil {
 			select {
 			case <-a.ctx.Done():
-				err = nil
+				err = ErrServerClosed
 				return
 			default:
 			}
-			if ne, ok := err.(net.Error); ok && ne.Temporary() {
-				maxDelay := time.Duration(atomic.LoadInt64((*int64)(&maxTempDelay)))
-				if maxDelay > 0 && totalDelay > maxDelay {
+			if a.lisStopped.Load() || a.closing.Load() {
+				err = ErrServerClosed
+				return
+			}
+			if dl != nil && isTimeout(err) {
+				if time.Now().Before(a.acceptDeadline()) {
+					// another Listener has accepted since the deadline was set
+					continue
+				}
+				a.cancel()
+				err = ErrServerClosed
+				return
+			}
+			a.stats.acceptErrors.Add(1)
+			if a.Metrics != nil {
+				a.Metrics.AcceptError(err)
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if retriable, exhausted := classifyError(err); retriable {
+				delay, ok := td.next(exhausted)
+				if !ok {
+					a.logf("accepter: accept error: %v; max temporary delay %v exceeded", err, delay)
 					return
 				}
-				if tempDelay == 0 {
-					tempDelay = 5 * time.Millisecond
+				if exhausted {
+					a.logf("accepter: accept error: %v; file descriptors exhausted, retrying in %v", err, delay)
 				} else {
-					tempDelay *= 2
+					a.logf("accepter: accept error: %v; retrying in %v", err, delay)
 				}
-				if max := 1 * time.Second; tempDelay > max {
-					tempDelay = max
+				temps++
+				a.reportError(err)
+				if !sleep(a.ctx, delay) {
+					err = ErrServerClosed
+					return
 				}
-				time.Sleep(tempDelay)
-				totalDelay += tempDelay
 				continue
 			}
+			a.logf("accepter: accept error: %v", err)
+			return
+		}
+		td.reset()
+		if a.AcceptTimeout > 0 {
+			a.lastAccept.Store(time.Now().UnixNano())
+		}
+		if a.closing.Load() {
+			conn.Close()
+			return ErrServerClosed
+		}
+		if a.stopAccept.Load() {
+			conn.Close()
+			continue
+		}
+		a.stats.totalAccepted.Add(1)
+		if a.Metrics != nil {
+			a.Metrics.ConnAccepted()
+		}
+		if l := a.AcceptRateLimit; l != nil && a.AcceptRateLimitReject && !l.Allow() {
+			a.reportReject(conn, "accept rate limit exceeded")
+			conn.Close()
+			continue
+		}
+		if a.OnAccept != nil && !a.OnAccept(conn) {
+			a.reportReject(conn, "refused by OnAccept")
+			conn.Close()
+			continue
+		}
+		if conn = a.wrapAccepted(conn); conn == nil {
+			continue
+		}
+		switch a.track(conn) {
+		case nil:
+			if max := a.MaxLifetimeConns; max > 0 {
+				n := a.servedConns.Add(1)
+				if n > max {
+					a.untrack(conn)
+					conn.Close()
+					return ErrServerClosed
+				}
+				if n == max {
+					a.dispatch(conn)
+					a.stopListeners()
+					return ErrServerClosed
+				}
+			}
+			a.dispatch(conn)
+		case errLimitExceeded:
+			go a.reject(conn)
+		default:
+			conn.Close()
+			err = ErrServerClosed
 			return
 		}
-		tempDelay = 0
-		totalDelay = 0
-		go a.serve(conn)
 	}
 }
 
 // ServeTLS accepts incoming connections on the Listener lis, creating a
 // new service goroutine for each. The service goroutines read requests and
 // then call a.Handler to reply to them. ServeTLS always closes lis unless returned error
-// is ErrAlreadyServed or as TLSError. ServeTLS returns a nil error after
+// is ErrAlreadyServed or as TLSError. ServeTLS returns ErrServerClosed after
 // Close or Shutdown method called.
 //
 // Additionally, files containing a certificate and matching private key for
-// the Accepter must be provided if neither the Accepter's TLSConfig.Certificates
-// nor TLSConfig.GetCertificate are populated. If the certificate is signed by
-// a certificate authority, the certFile should be the concatenation of the
-// Accepter's certificate, any intermediates, and the CA's certificate.
+// the Accepter must be provided if none of the Accepter's TLSConfig.Certificates,
+// TLSConfig.GetCertificate and TLSConfig.GetConfigForClient are populated. If the
+// certificate is signed by a certificate authority, the certFile should be the
+// concatenation of the Accepter's certificate, any intermediates, and the CA's
+// certificate.
 func (a *Accepter) ServeTLS(lis net.Listener, certFile, keyFile string) (err error) {
-	var config *tls.Config
-	if a.TLSConfig != nil {
-		config = a.TLSConfig.Clone()
-	} else {
-		config = &tls.Config{}
+	config := a.cloneTLSConfig()
+
+	protos := make([]string, 0, len(a.TLSNextProto))
+	for proto := range a.TLSNextProto {
+		if !strSliceContains(config.NextProtos, proto) {
+			protos = append(protos, proto)
+		}
+	}
+	if len(protos) > 0 {
+		sort.Strings(protos)
+		config.NextProtos = append(append([]string(nil), config.NextProtos...), protos...)
 	}
 
-	configHasCert := len(config.Certificates) > 0 || config.GetCertificate != nil
+	configHasCert := len(config.Certificates) > 0 || config.GetCertificate != nil || config.GetConfigForClient != nil
 	if !configHasCert || certFile != "" || keyFile != "" {
 		config.Certificates = make([]tls.Certificate, 1)
 		config.Certificates[0], err = tls.LoadX509KeyPair(certFile, keyFile)
@@ -223,19 +1242,666 @@ func (a *Accepter) ServeTLS(lis net.Listener, certFile, keyFile string) (err err
 		}
 	}
 
-	return a.Serve(tls.NewListener(lis, config))
+	return a.serveListeners([]net.Listener{lis}, func(lis net.Listener) net.Listener {
+		return tls.NewListener(a.wrapListener(lis), config)
+	}, nil)
 }
 
-func (a *Accepter) serve(conn net.Conn) {
+// listenConfig returns the net.ListenConfig to create listeners according to the options of
+// the Accepter.
+func (a *Accepter) listenConfig() *net.ListenConfig {
+	lc := a.ListenConfig
+	if a.ReusePort {
+		control := lc.Control
+		lc.Control = func(network, address string, c syscall.RawConn) error {
+			if control != nil {
+				if err := control(network, address, c); err != nil {
+					return err
+				}
+			}
+			return reusePortControl(network, address, c)
+		}
+	}
+	return &lc
+}
+
+// wrapAccepted returns conn wrapped by ConnWrapper if it's set. It closes conn and returns nil
+// if ConnWrapper returns nil.
+func (a *Accepter) wrapAccepted(conn net.Conn) net.Conn {
+	if a.ConnWrapper == nil {
+		return conn
+	}
+	wrapped := a.ConnWrapper(conn)
+	if wrapped == nil {
+		conn.Close()
+	}
+	return wrapped
+}
+
+// ServeConn serves the already accepted connection conn like the connections accepted by
+// Serve, and blocks until the handler returns. The connection is also wrapped by
+// ConnWrapper. The connection is tracked, so it takes part in Shutdown and Close; and
+// connection limits apply to it. The context passed to the handler is derived from ctx,
+// and it's also cancelled on Shutdown or Close. ServeConn always closes conn. It returns
+// ErrNotServing if the Accepter isn't serving. ServeConn is safe to call concurrently
+// with Serve.
+func (a *Accepter) ServeConn(ctx context.Context, conn net.Conn) error {
+	a.mu.RLock()
+	actx := a.ctx
+	a.mu.RUnlock()
+	if actx == nil {
+		conn.Close()
+		return ErrNotServing
+	}
+	if conn = a.wrapAccepted(conn); conn == nil {
+		return nil
+	}
+
+	switch a.track(conn) {
+	case nil:
+	case errLimitExceeded:
+		a.reject(conn)
+		return nil
+	default:
+		conn.Close()
+		return ErrNotServing
+	}
+
+	ctx, ctxCancel := context.WithCancel(ctx)
+	defer ctxCancel()
+	go func() {
+		select {
+		case <-actx.Done():
+			ctxCancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	a.serve(ctx, conn)
+	return nil
+}
+
+// wrapListener wraps lis according to the options of the Accepter.
+func (a *Accepter) wrapListener(lis net.Listener) net.Listener {
+	if a.ProxyProtocol {
+		lis = &proxyListener{Listener: lis}
+	}
+	if a.ListenerWrapper != nil {
+		lis = a.ListenerWrapper(lis)
+	}
+	return lis
+}
+
+// logf logs to ErrorLog, or to the standard logger if DefaultErrorLog is true.
+func (a *Accepter) logf(format string, args ...interface{}) {
+	if a.ErrorLog != nil {
+		a.ErrorLog.Printf(format, args...)
+		return
+	}
+	if a.DefaultErrorLog {
+		log.Printf(format, args...)
+	}
+}
+
+// track adds conn to the tracked connections. It returns the context error without
+// tracking if the Accepter has been cancelled, or errLimitExceeded if MaxConnections
+// has been reached.
+func (a *Accepter) track(conn net.Conn) error {
 	a.connsMu.Lock()
-	a.conns[conn] = struct{}{}
-	a.connsMu.Unlock()
+	defer a.connsMu.Unlock()
+	if err := a.ctx.Err(); err != nil {
+		return err
+	}
+	if a.MaxConnections > 0 && len(a.conns) >= a.MaxConnections {
+		return errLimitExceeded
+	}
+	cd := &connData{
+		id:        a.lastConnID.Add(1),
+		startedAt: time.Now(),
+	}
+	if !a.ProxyProtocol {
+		cd.remoteAddr, cd.localAddr = conn.RemoteAddr(), conn.LocalAddr()
+	}
+	a.conns[conn] = cd
+	return nil
+}
 
-	a.Handler.Serve(a.ctx, conn)
+// updateConnData calls f with the data of the tracked connection conn under a.connsMu. It
+// does nothing if conn isn't tracked.
+func (a *Accepter) updateConnData(conn net.Conn, f func(cd *connData)) {
+	a.connsMu.Lock()
+	defer a.connsMu.Unlock()
+	if cd := a.conns[conn]; cd != nil {
+		f(cd)
+	}
+}
 
-	conn.Close()
+// ConnID returns the ID of the tracked connection conn, or zero if conn isn't tracked. The
+// connections get unique and increasing IDs starting from 1 when they're accepted. It can be
+// used in the ConnState hook to correlate the states of a connection.
+func (a *Accepter) ConnID(conn net.Conn) uint64 {
+	a.connsMu.RLock()
+	defer a.connsMu.RUnlock()
+	if cd := a.conns[conn]; cd != nil {
+		return cd.id
+	}
+	return 0
+}
+
+// untrack removes conn from the tracked connections, and signals waiting
+// Shutdown calls when nothing remains.
+func (a *Accepter) untrack(conn net.Conn) {
+	a.untrackConn(conn, false)
+}
+
+// untrackConn is like untrack, and counts conn as hijacked rather than closed if hijacked.
+func (a *Accepter) untrackConn(conn net.Conn, hijacked bool) {
+	a.connsMu.RLock()
+	cd := a.conns[conn]
+	opened := cd != nil && cd.opened
+	a.connsMu.RUnlock()
+
+	if opened {
+		d := time.Since(cd.startedAt)
+		if a.OnConnClosed != nil {
+			a.OnConnClosed(conn, d)
+		}
+		if a.Metrics != nil {
+			a.Metrics.ConnClosed(d)
+		}
+	}
 
 	a.connsMu.Lock()
 	delete(a.conns, conn)
+	switch {
+	case hijacked:
+		a.stats.totalHijacked.Add(1)
+	case opened:
+		a.stats.totalClosed.Add(1)
+	}
+	a.signalDrained()
 	a.connsMu.Unlock()
 }
+
+// signalDrained signals waiting Shutdown calls if no connection or packet remains. It also
+// closes the channel returned by Done if serving has stopped.
+// It must be called with a.connsMu locked.
+func (a *Accepter) signalDrained() {
+	if len(a.conns) != 0 || a.packets != 0 {
+		return
+	}
+	if a.connsDone != nil {
+		close(a.connsDone)
+		a.connsDone = nil
+	}
+	if a.stopped && a.done != nil && !a.doneClosed {
+		close(a.done)
+		a.doneClosed = true
+	}
+}
+
+// drained returns a channel that's closed when no connection or packet remains, or nil if
+// none remains already.
+func (a *Accepter) drained() <-chan struct{} {
+	a.connsMu.Lock()
+	defer a.connsMu.Unlock()
+	if len(a.conns) == 0 && a.packets == 0 {
+		return nil
+	}
+	if a.connsDone == nil {
+		a.connsDone = make(chan struct{})
+	}
+	return a.connsDone
+}
+
+// stop marks serving as stopped after the accept or read loop returned.
+func (a *Accepter) stop() {
+	a.connsMu.Lock()
+	defer a.connsMu.Unlock()
+	a.stopped = true
+	a.signalDrained()
+}
+
+// Done returns a channel that's closed when serving has stopped and all of the connections
+// and packet handlers have finished, e.g. after Serve returned by Shutdown.
+func (a *Accepter) Done() <-chan struct{} {
+	a.connsMu.Lock()
+	defer a.connsMu.Unlock()
+	if a.done == nil {
+		a.done = make(chan struct{})
+		a.signalDrained()
+	}
+	return a.done
+}
+
+// trackIP counts a connection from ip. It returns false without counting if
+// MaxConnsPerIP has been reached for ip.
+func (a *Accepter) trackIP(ip string) bool {
+	a.connsMu.Lock()
+	defer a.connsMu.Unlock()
+	if a.ipConns[ip] >= a.MaxConnsPerIP {
+		return false
+	}
+	if a.ipConns == nil {
+		a.ipConns = make(map[string]int)
+	}
+	a.ipConns[ip]++
+	return true
+}
+
+// untrackIP uncounts a connection from ip.
+func (a *Accepter) untrackIP(ip string) {
+	a.connsMu.Lock()
+	defer a.connsMu.Unlock()
+	if a.ipConns[ip]--; a.ipConns[ip] <= 0 {
+		delete(a.ipConns, ip)
+	}
+}
+
+// connIP returns the remote IP address of conn as string. If the remote address has no IP,
+// it returns its string form.
+func connIP(conn net.Conn) string {
+	switch addr := conn.RemoteAddr().(type) {
+	case *net.TCPAddr:
+		return addr.IP.String()
+	case *net.UDPAddr:
+		return addr.IP.String()
+	case nil:
+		return ""
+	default:
+		s := addr.String()
+		if host, _, err := net.SplitHostPort(s); err == nil {
+			return host
+		}
+		return s
+	}
+}
+
+// readProxyHeader reads the PROXY protocol header of conn by getting its remote address,
+// so that the checks by the remote address don't block longer than ReadTimeout or
+// IdleTimeout, whichever is shorter, before the deadlines of serving are set.
+func (a *Accepter) readProxyHeader(conn net.Conn) {
+	d := a.ReadTimeout
+	if t := a.IdleTimeout; t > 0 && (d <= 0 || t < d) {
+		d = t
+	}
+	if d > 0 {
+		conn.SetReadDeadline(time.Now().Add(d))
+		defer conn.SetReadDeadline(time.Time{})
+	}
+	conn.RemoteAddr()
+}
+
+// reject calls OnLimitExceeded if it is set, and then closes conn.
+func (a *Accepter) reject(conn net.Conn) {
+	defer conn.Close()
+	a.reportReject(conn, errLimitExceeded.Error())
+	if a.OnLimitExceeded != nil {
+		a.OnLimitExceeded(conn)
+	}
+}
+
+// serve serves conn with a context derived from parent.
+func (a *Accepter) serve(parent context.Context, conn net.Conn) {
+	if a.ProxyProtocol && (a.ipFilter != nil || a.MaxConnsPerIP > 0) {
+		a.readProxyHeader(conn)
+	}
+
+	if a.ipFilter != nil && !a.ipFilter.allowed(conn) {
+		a.reportReject(conn, "filtered by CIDR")
+		conn.Close()
+		a.untrack(conn)
+		return
+	}
+
+	if a.MaxConnsPerIP > 0 {
+		ip := connIP(conn)
+		if !a.trackIP(ip) {
+			a.reject(conn)
+			a.untrack(conn)
+			return
+		}
+		defer a.untrackIP(ip)
+	}
+
+	id := a.ConnID(conn)
+	h := &hijacker{
+		a:    a,
+		conn: conn,
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			a.handlePanic(conn, id, e)
+		}
+		if h.isHijacked() {
+			return
+		}
+		a.setState(conn, StateClosed)
+		conn.Close()
+		a.untrack(conn)
+	}()
+
+	a.updateConnData(conn, func(cd *connData) {
+		cd.opened = true
+	})
+	a.setState(conn, StateNew)
+
+	a.mu.RLock()
+	sctx := a.ctx
+	a.mu.RUnlock()
+	ctx := context.WithValue(parent, ConnIDContextKey, id)
+	ctx = context.WithValue(ctx, ShutdownContextKey, sctx)
+	ctx, ctxCancel := context.WithCancel(ctx)
+	defer ctxCancel()
+	a.updateConnData(conn, func(cd *connData) {
+		cd.cancel = ctxCancel
+	})
+	done := make(chan struct{})
+	defer close(done)
+
+	if a.MaxConnLifetime > 0 {
+		t := time.AfterFunc(a.MaxConnLifetime, func() {
+			ctxCancel()
+			if !h.isHijacked() {
+				conn.Close()
+			}
+		})
+		defer t.Stop()
+	}
+
+	if a.sem != nil {
+		if !a.acquire(ctx) {
+			return
+		}
+		defer a.release()
+	}
+
+	ctx, c := a.wrapConn(ctx, conn, h)
+	if a.ConfigureConn != nil {
+		if err := a.ConfigureConn(conn); err != nil {
+			if a.OnHandlerError != nil {
+				a.OnHandlerError(conn, err)
+				return
+			}
+			a.logf("accepter: error configuring %v (conn %d): %v", conn.RemoteAddr(), id, err)
+			return
+		}
+	}
+	if tc, ok := conn.(*tls.Conn); ok {
+		if err := a.handshake(ctx, tc); err != nil {
+			if a.OnHandlerError != nil {
+				a.OnHandlerError(conn, wrapTLSError(err))
+				return
+			}
+			a.logf("accepter: TLS handshake error from %v (conn %d): %v", conn.RemoteAddr(), id, err)
+			return
+		}
+		state := tc.ConnectionState()
+		if fn := a.TLSNextProto[state.NegotiatedProtocol]; fn != nil {
+			// fn reads tc directly, so the idle deadline would never be extended
+			if ic, ok := c.(*idleConn); ok {
+				ic.stop()
+			}
+			a.setState(conn, StateActive)
+			fn(a, tc, a.Handler)
+			return
+		}
+		ctx = context.WithValue(ctx, TLSStateContextKey, state)
+	}
+
+	c = a.wrapReadAhead(c, ctxCancel, done)
+	if !a.DetectClose {
+		h.c = c
+		ctx = context.WithValue(ctx, HijackContextKey, h)
+	}
+
+	remoteAddr, localAddr := c.RemoteAddr(), c.LocalAddr()
+	ctx = context.WithValue(ctx, RemoteAddrContextKey, remoteAddr)
+	ctx = context.WithValue(ctx, LocalAddrContextKey, localAddr)
+	bc, _ := ctx.Value(BytesContextKey).(*byteCounter)
+	a.updateConnData(conn, func(cd *connData) {
+		cd.remoteAddr, cd.localAddr = remoteAddr, localAddr
+		cd.bytes = bc
+	})
+
+	if a.Logger != nil {
+		ctx = context.WithValue(ctx, LoggerContextKey, Logger(newConnLogger(a.Logger, id, remoteAddr)))
+	}
+
+	if a.ConnContext != nil {
+		ctx = a.ConnContext(ctx, c)
+		if ctx == nil {
+			panic("ConnContext returned nil")
+		}
+	}
+
+	if a.HandlerTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.HandlerTimeout)
+		defer cancel()
+		t := time.AfterFunc(a.HandlerTimeout, func() {
+			if !h.isHijacked() {
+				conn.Close()
+			}
+		})
+		defer t.Stop()
+	}
+
+	a.setState(conn, StateActive)
+	if a.ProfileLabels {
+		pprof.Do(ctx, profileLabels(id, remoteAddr), func(ctx context.Context) {
+			a.serveHandler(ctx, c, id)
+		})
+		return
+	}
+	a.serveHandler(ctx, c, id)
+}
+
+// serveHandler invokes the handler for the connection c with the given id.
+func (a *Accepter) serveHandler(ctx context.Context, c net.Conn, id uint64) {
+	if a.ErrorHandler == nil {
+		a.Handler.Serve(ctx, c)
+		return
+	}
+	if err := a.ErrorHandler.Serve(ctx, c); err != nil {
+		if a.OnHandlerError != nil {
+			a.OnHandlerError(c, err)
+			return
+		}
+		a.logf("accepter: error serving %v (conn %d): %v", c.RemoteAddr(), id, err)
+	}
+}
+
+// profileLabels returns the profiler labels of the connection with the given id and
+// remote address.
+func profileLabels(id uint64, remoteAddr net.Addr) pprof.LabelSet {
+	addr := ""
+	if remoteAddr != nil {
+		addr = remoteAddr.String()
+	}
+	return pprof.Labels("accepter.conn_id", strconv.FormatUint(id, 10), "accepter.remote_addr", addr)
+}
+
+// handshake runs the TLS handshake of conn, limited by TLSHandshakeTimeout. The connection
+// is closed if ctx is done or the timeout expires before the handshake completes.
+func (a *Accepter) handshake(ctx context.Context, conn *tls.Conn) error {
+	if a.TLSHandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.TLSHandshakeTimeout)
+		defer cancel()
+	}
+	return conn.HandshakeContext(ctx)
+}
+
+// acquire waits for a slot to run a handler. It returns false if ctx is done or
+// QueueTimeout expires before. A slot acquired while ctx is done is released, since
+// select may choose it over ctx.Done.
+func (a *Accepter) acquire(ctx context.Context) bool {
+	var timeout <-chan time.Time
+	if a.QueueTimeout > 0 {
+		t := time.NewTimer(a.QueueTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case a.sem <- struct{}{}:
+		if ctx.Err() != nil {
+			a.release()
+			return false
+		}
+		return true
+	case <-ctx.Done():
+		return false
+	case <-timeout:
+		return false
+	}
+}
+
+// release releases the slot acquired by acquire.
+func (a *Accepter) release() {
+	<-a.sem
+}
+
+// wrapConn applies the connection options of the Accepter to conn, and wraps it if needed.
+// It returns the wrapped connection, and the connection context ctx with the values of the
+// wrappers. The rate limits keep working after the connection is hijacked by h.
+func (a *Accepter) wrapConn(ctx context.Context, conn net.Conn, h *hijacker) (context.Context, net.Conn) {
+	raw := conn
+	if tc, ok := raw.(*tls.Conn); ok {
+		raw = tc.NetConn()
+	}
+	if pc, ok := raw.(*proxyConn); ok {
+		raw = pc.Conn
+	}
+	if tc, ok := raw.(*net.TCPConn); ok {
+		a.setTCPOptions(tc)
+	}
+
+	if a.CountBytes {
+		bc := &byteCounter{
+			stats: &a.stats,
+		}
+		ctx = context.WithValue(ctx, BytesContextKey, bc)
+		conn = &countConn{
+			Conn:    conn,
+			counter: bc,
+		}
+	}
+
+	var readers []*RateLimiter
+	if a.ReadBytesPerSec > 0 {
+		readers = append(readers, NewRateLimiter(float64(a.ReadBytesPerSec), a.ReadBytesPerSec))
+	}
+	if a.ReadRateLimiter != nil {
+		readers = append(readers, a.ReadRateLimiter)
+	}
+	var writers []*RateLimiter
+	if a.WriteBytesPerSec > 0 {
+		writers = append(writers, NewRateLimiter(float64(a.WriteBytesPerSec), a.WriteBytesPerSec))
+	}
+	if a.WriteRateLimiter != nil {
+		writers = append(writers, a.WriteRateLimiter)
+	}
+	if len(readers) > 0 || len(writers) > 0 {
+		// the waits are cancelled with ctx, unless the connection is hijacked
+		lctx, lcancel := context.WithCancel(context.WithoutCancel(ctx))
+		context.AfterFunc(ctx, func() {
+			if !h.isHijacked() {
+				lcancel()
+			}
+		})
+		conn = &rateConn{
+			Conn:    conn,
+			ctx:     lctx,
+			readers: readers,
+			writers: writers,
+		}
+	}
+
+	now := time.Now()
+	var readLimit, writeLimit time.Time
+	if a.ReadTimeout > 0 {
+		readLimit = now.Add(a.ReadTimeout)
+		conn.SetReadDeadline(readLimit)
+	}
+	if a.WriteTimeout > 0 {
+		if a.WriteTimeoutPerWrite {
+			conn = &writeTimeoutConn{
+				Conn:    conn,
+				timeout: a.WriteTimeout,
+			}
+		} else {
+			writeLimit = now.Add(a.WriteTimeout)
+			conn.SetWriteDeadline(writeLimit)
+		}
+	}
+	if a.IdleTimeout > 0 {
+		conn = newIdleConn(conn, a.IdleTimeout, readLimit, writeLimit, a.IdleTimeoutOverridable)
+	}
+	return ctx, conn
+}
+
+// wrapReadAhead wraps conn to read ahead according to the options of the Accepter. It's
+// applied after the TLS handshake, so that reading ahead doesn't take the data of the
+// TLSNextProto functions. The wrappers may call cancel to cancel the connection context,
+// and they must stop when done is closed after the handler returned.
+func (a *Accepter) wrapReadAhead(conn net.Conn, cancel context.CancelFunc, done <-chan struct{}) net.Conn {
+	if a.DetectClose {
+		conn = newDetectCloseConn(conn, cancel, done, a.BufferPool)
+	}
+	if a.Peekable {
+		conn = newPeekConn(conn)
+	}
+	return conn
+}
+
+// setTCPOptions applies the TCP options of the Accepter to conn.
+func (a *Accepter) setTCPOptions(conn *net.TCPConn) {
+	if a.KeepAlive {
+		conn.SetKeepAlive(true)
+		if a.KeepAlivePeriod > 0 {
+			conn.SetKeepAlivePeriod(a.KeepAlivePeriod)
+		}
+	}
+	if a.NoDelay != nil {
+		conn.SetNoDelay(*a.NoDelay)
+	}
+	if a.Linger != nil {
+		conn.SetLinger(*a.Linger)
+	}
+}
+
+// handlePanic calls PanicHandler if it is set, otherwise logs the recovered value
+// with the stack trace.
+func (a *Accepter) handlePanic(conn net.Conn, id uint64, recovered interface{}) {
+	a.countPanic()
+	if a.PanicHandler != nil {
+		a.PanicHandler(conn, recovered)
+		return
+	}
+	a.logf("accepter: panic serving %v (conn %d): %v\n%s", conn.RemoteAddr(), id, recovered, stack())
+}
+
+// countPanic counts a panic recovered from a handler.
+func (a *Accepter) countPanic() {
+	a.stats.handlerPanics.Add(1)
+	if a.Metrics != nil {
+		a.Metrics.HandlerPanic()
+	}
+}
+
+func strSliceContains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// stack returns the formatted stack trace of the calling goroutine.
+func stack() []byte {
+	const size = 64 << 10
+	buf := make([]byte, size)
+	return buf[:runtime.Stack(buf, false)]
+}
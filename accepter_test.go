@@ -0,0 +1,460 @@
+package accepter
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// serveTCP runs Serve on a new local Listener in the background, and returns the address
+// and the channel of the error returned by Serve.
+func serveTCP(t *testing.T, a *Accepter) (string, <-chan error) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- a.Serve(lis)
+	}()
+	return lis.Addr().String(), errc
+}
+
+// dial connects to addr by TCP.
+func dial(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// shutdown shuts down a, and checks the errors of Shutdown and of errc returned by serveTCP.
+func shutdown(t *testing.T, a *Accepter, errc <-chan error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := a.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown returned %v", err)
+	}
+	if err := <-errc; err != ErrServerClosed {
+		t.Fatalf("Serve returned %v", err)
+	}
+}
+
+// waitClosed waits until the peer closes conn, and returns the duration waited. It fails if
+// the peer doesn't close conn in 5 seconds.
+func waitClosed(t *testing.T, conn net.Conn) time.Duration {
+	t.Helper()
+	start := time.Now()
+	conn.SetReadDeadline(start.Add(5 * time.Second))
+	b := make([]byte, 1)
+	for {
+		if _, err := conn.Read(b); err != nil {
+			if isTimeout(err) {
+				t.Fatal("connection isn't closed")
+			}
+			return time.Since(start)
+		}
+	}
+}
+
+func TestProxyProtocolFilterTimeout(t *testing.T) {
+	a := &Accepter{
+		ProxyProtocol: true,
+		ReadTimeout:   100 * time.Millisecond,
+		AllowCIDRs:    []string{"10.0.0.0/8"},
+		Handler: HandlerFunc(func(ctx context.Context, conn net.Conn) {
+			t.Error("handler is invoked")
+		}),
+	}
+	addr, errc := serveTCP(t, a)
+
+	conn := dial(t, addr)
+	defer conn.Close()
+	if d := waitClosed(t, conn); d > time.Second {
+		t.Errorf("connection is closed after %v", d)
+	}
+
+	shutdown(t, a, errc)
+}
+
+func TestProxyProtocolMaxConnsPerIPTimeout(t *testing.T) {
+	a := &Accepter{
+		ProxyProtocol: true,
+		ReadTimeout:   100 * time.Millisecond,
+		MaxConnsPerIP: 1,
+		Handler: HandlerFunc(func(ctx context.Context, conn net.Conn) {
+			conn.Read(make([]byte, 1))
+		}),
+		NumWorkers: 1,
+	}
+	addr, errc := serveTCP(t, a)
+
+	conn := dial(t, addr)
+	defer conn.Close()
+	if d := waitClosed(t, conn); d > time.Second {
+		t.Errorf("connection is closed after %v", d)
+	}
+
+	shutdown(t, a, errc)
+}
+
+func TestConcurrentCloseShutdown(t *testing.T) {
+	started := make(chan struct{}, 1)
+	a := &Accepter{
+		Handler: HandlerFunc(func(ctx context.Context, conn net.Conn) {
+			started <- struct{}{}
+			<-ctx.Done()
+		}),
+	}
+	addr, errc := serveTCP(t, a)
+	conn := dial(t, addr)
+	defer conn.Close()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	errs := make(chan error, 20)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			errs <- a.Close()
+		}()
+		go func() {
+			defer wg.Done()
+			errs <- a.Shutdown(ctx)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Close or Shutdown returned %v", err)
+		}
+	}
+	if err := <-errc; err != ErrServerClosed {
+		t.Fatalf("Serve returned %v", err)
+	}
+	if err := a.Close(); err != nil {
+		t.Errorf("Close after Shutdown returned %v", err)
+	}
+	if err := a.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown after Close returned %v", err)
+	}
+}
+
+func TestShutdownBeforeServe(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		a := &Accepter{
+			Handler: HandlerFunc(func(ctx context.Context, conn net.Conn) {}),
+		}
+		addr, errc := serveTCP(t, a)
+		if err := a.Shutdown(context.Background()); err != nil && err != ErrNotServing {
+			t.Fatalf("Shutdown returned %v", err)
+		}
+		select {
+		case err := <-errc:
+			if err != ErrServerClosed {
+				t.Fatalf("Serve returned %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Serve doesn't return")
+		}
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			t.Fatal("Listener isn't closed")
+		}
+	}
+}
+
+func TestListenAndServeContextCancelBeforeServe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	a := &Accepter{
+		BaseContext: func(net.Listener) context.Context {
+			cancel()
+			// let the cancellation be handled before serving starts
+			time.Sleep(50 * time.Millisecond)
+			return context.Background()
+		},
+		Handler: HandlerFunc(func(ctx context.Context, conn net.Conn) {}),
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- a.ListenAndServeContext(ctx, "tcp", "127.0.0.1:0")
+	}()
+	select {
+	case err := <-errc:
+		if err != ErrServerClosed {
+			t.Fatalf("ListenAndServeContext returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		a.Close()
+		t.Fatal("ListenAndServeContext doesn't return")
+	}
+}
+
+func TestServeTwice(t *testing.T) {
+	started := make(chan struct{})
+	a := &Accepter{
+		OnServe: func(net.Listener) {
+			close(started)
+		},
+		Handler: HandlerFunc(func(ctx context.Context, conn net.Conn) {}),
+	}
+	_, errc := serveTCP(t, a)
+	<-started
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	if err := a.Serve(lis); err != ErrAlreadyServed {
+		t.Fatalf("second Serve returned %v", err)
+	}
+	conn := dial(t, lis.Addr().String())
+	conn.Close()
+
+	shutdown(t, a, errc)
+}
+
+func TestMaxLifetimeConns(t *testing.T) {
+	release := make(chan struct{})
+	a := &Accepter{
+		MaxLifetimeConns: 1,
+		Concurrency:      1,
+		Handler: HandlerFunc(func(ctx context.Context, conn net.Conn) {
+			<-release
+			if err := ctx.Err(); err != nil {
+				t.Errorf("handler context is done: %v", err)
+			}
+			conn.Write([]byte("ok"))
+		}),
+	}
+	addr, errc := serveTCP(t, a)
+
+	conn := dial(t, addr)
+	defer conn.Close()
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case err := <-errc:
+		t.Fatalf("Serve returned %v before the connection is finished", err)
+	default:
+	}
+	close(release)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(conn, b); err != nil || string(b) != "ok" {
+		t.Fatalf("read %q, %v", b, err)
+	}
+	if err := <-errc; err != ErrServerClosed {
+		t.Fatalf("Serve returned %v", err)
+	}
+}
+
+func TestForcedCloseCancelsContextFirst(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		close func(a *Accepter) error
+	}{
+		{"Close", (*Accepter).Close},
+		{"ShutdownTimeout", func(a *Accepter) error {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			a.Shutdown(ctx)
+			return nil
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			started := make(chan struct{})
+			ctxErr := make(chan error, 1)
+			a := &Accepter{
+				Handler: HandlerFunc(func(ctx context.Context, conn net.Conn) {
+					close(started)
+					// ignore the cancellation, and block on reading until the connection is closed
+					conn.Read(make([]byte, 1))
+					ctxErr <- ctx.Err()
+				}),
+			}
+			addr, errc := serveTCP(t, a)
+
+			conn := dial(t, addr)
+			defer conn.Close()
+			<-started
+			if err := tt.close(a); err != nil {
+				t.Fatal(err)
+			}
+			if err := <-ctxErr; err == nil {
+				t.Error("context isn't done when the read fails")
+			}
+			if err := <-errc; err != ErrServerClosed {
+				t.Fatalf("Serve returned %v", err)
+			}
+		})
+	}
+}
+
+// racingListener is a net.Listener whose Close makes a connection acceptable, and gives
+// it the time to be accepted before returning, as if it was accepted concurrently with
+// closing.
+type racingListener struct {
+	net.Listener
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (l *racingListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	default:
+	}
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *racingListener) Close() error {
+	l.once.Do(func() {
+		l.Listener.Close()
+		client, server := net.Pipe()
+		l.conns <- server
+		l.conns <- client
+		close(l.closed)
+		time.Sleep(50 * time.Millisecond)
+	})
+	return nil
+}
+
+func TestNoConnHandledAfterShutdown(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis := &racingListener{
+		Listener: inner,
+		conns:    make(chan net.Conn, 2),
+		closed:   make(chan struct{}),
+	}
+	var (
+		mu      sync.Mutex
+		handled int
+	)
+	serving := make(chan struct{})
+	a := &Accepter{
+		OnServe: func(net.Listener) {
+			close(serving)
+		},
+		Handler: HandlerFunc(func(ctx context.Context, conn net.Conn) {
+			mu.Lock()
+			handled++
+			mu.Unlock()
+		}),
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- a.Serve(lis)
+	}()
+	<-serving
+
+	shutdown(t, a, errc)
+	if handled != 0 {
+		t.Errorf("%d connections are handled after Shutdown begins", handled)
+	}
+}
+
+func TestAcquireCancelled(t *testing.T) {
+	a := &Accepter{
+		sem: make(chan struct{}, 1),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 100; i++ {
+		if a.acquire(ctx) {
+			t.Fatal("slot is acquired with a cancelled context")
+		}
+		if len(a.sem) != 0 {
+			t.Fatal("slot isn't released")
+		}
+	}
+}
+
+func TestOnConnClosedRejected(t *testing.T) {
+	closed := make(chan struct{}, 1)
+	a := &Accepter{
+		AllowCIDRs: []string{"10.0.0.0/8"},
+		OnConnClosed: func(conn net.Conn, d time.Duration) {
+			closed <- struct{}{}
+		},
+		Handler: HandlerFunc(func(ctx context.Context, conn net.Conn) {
+			t.Error("handler is invoked")
+		}),
+	}
+	addr, errc := serveTCP(t, a)
+
+	conn := dial(t, addr)
+	defer conn.Close()
+	waitClosed(t, conn)
+
+	shutdown(t, a, errc)
+	select {
+	case <-closed:
+		t.Error("OnConnClosed is called for a rejected connection")
+	default:
+	}
+	if s := a.Stats(); s.TotalClosed != 0 {
+		t.Errorf("Stats returned %+v", s)
+	}
+}
+
+func TestServeManyAcceptTimeout(t *testing.T) {
+	var listeners []net.Listener
+	for i := 0; i < 2; i++ {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners = append(listeners, lis)
+	}
+	a := &Accepter{
+		AcceptTimeout: 200 * time.Millisecond,
+		Handler:       HandlerFunc(func(ctx context.Context, conn net.Conn) {}),
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- a.ServeMany(listeners...)
+	}()
+
+	// keep the first Listener busy, and the second one idle
+	for i := 0; i < 12; i++ {
+		time.Sleep(50 * time.Millisecond)
+		select {
+		case err := <-errc:
+			t.Fatalf("ServeMany returned %v while a Listener is busy", err)
+		default:
+		}
+		dial(t, listeners[0].Addr().String()).Close()
+	}
+
+	select {
+	case err := <-errc:
+		if err != ErrServerClosed {
+			t.Fatalf("ServeMany returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServeMany doesn't return after all Listeners are idle")
+	}
+}
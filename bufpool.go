@@ -0,0 +1,61 @@
+package accepter
+
+import (
+	"io"
+	"sync"
+)
+
+// A BufferPool is a pool of byte slices to reuse buffers, like httputil.BufferPool. The
+// buffers are passed by pointer, so they can be kept in a sync.Pool without allocating.
+type BufferPool interface {
+	Get() *[]byte
+	Put(*[]byte)
+}
+
+// defaultBufferSize is the size of the buffers created by NewBufferPool if the given size
+// isn't positive.
+const defaultBufferSize = 32 * 1024
+
+// NewBufferPool returns a BufferPool backed by sync.Pool, which creates the buffers with
+// the given size. Zero or negative values mean 32KiB.
+func NewBufferPool(size int) BufferPool {
+	if size <= 0 {
+		size = defaultBufferSize
+	}
+	return &bufferPool{
+		pool: sync.Pool{
+			New: func() interface{} {
+				b := make([]byte, size)
+				return &b
+			},
+		},
+	}
+}
+
+// bufferPool is the BufferPool returned by NewBufferPool.
+type bufferPool struct {
+	pool sync.Pool
+}
+
+// Get is implementation of BufferPool
+func (p *bufferPool) Get() *[]byte {
+	return p.pool.Get().(*[]byte)
+}
+
+// Put is implementation of BufferPool
+func (p *bufferPool) Put(b *[]byte) {
+	p.pool.Put(b)
+}
+
+// CopyBuffer copies from src to dst like io.CopyBuffer, with a buffer taken from pool and
+// put back after copying. If pool is nil, it's similar to io.Copy. Like io.CopyBuffer,
+// the buffer isn't used if src implements io.WriterTo or dst implements io.ReaderFrom,
+// e.g. both are *net.TCPConn.
+func CopyBuffer(dst io.Writer, src io.Reader, pool BufferPool) (written int64, err error) {
+	if pool == nil {
+		return io.Copy(dst, src)
+	}
+	buf := pool.Get()
+	defer pool.Put(buf)
+	return io.CopyBuffer(dst, src, *buf)
+}
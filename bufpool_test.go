@@ -0,0 +1,59 @@
+package accepter
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestBufferPoolPutAllocs(t *testing.T) {
+	pool := NewBufferPool(32 * 1024)
+	pool.Put(pool.Get())
+	allocs := testing.AllocsPerRun(100, func() {
+		pool.Put(pool.Get())
+	})
+	if allocs != 0 {
+		t.Errorf("Get and Put allocate %v times", allocs)
+	}
+}
+
+func TestNewBufferPoolDefaultSize(t *testing.T) {
+	for _, size := range []int{0, -1} {
+		pool := NewBufferPool(size)
+		if b := pool.Get(); len(*b) != defaultBufferSize {
+			t.Errorf("NewBufferPool(%d) created a buffer of %d bytes", size, len(*b))
+		}
+		data := []byte("hello")
+		var buf bytes.Buffer
+		if _, err := CopyBuffer(struct{ io.Writer }{&buf}, struct{ io.Reader }{bytes.NewReader(data)}, pool); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != "hello" {
+			t.Errorf("CopyBuffer copied %q", buf.String())
+		}
+	}
+}
+
+func BenchmarkCopyBuffer(b *testing.B) {
+	data := make([]byte, 64*1024)
+	for _, bb := range []struct {
+		name string
+		pool BufferPool
+	}{
+		{"NoPool", nil},
+		{"Pool", NewBufferPool(32 * 1024)},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+			r := bytes.NewReader(data)
+			for i := 0; i < b.N; i++ {
+				r.Reset(data)
+				// hide WriteTo and ReadFrom, so that the copying goes through the buffer
+				if _, err := CopyBuffer(struct{ io.Writer }{io.Discard}, struct{ io.Reader }{r}, bb.pool); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package accepter
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ipFilter filters connections by the remote IP address.
+type ipFilter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// newIPFilter parses the CIDRs in allow and deny, and returns the ipFilter. A single IP
+// address is parsed as the network of that address only. It returns nil if both of allow and
+// deny are empty.
+func newIPFilter(allow, deny []string) (f *ipFilter, err error) {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil, nil
+	}
+	f = new(ipFilter)
+	if f.allow, err = parseCIDRs(allow); err != nil {
+		return nil, err
+	}
+	if f.deny, err = parseCIDRs(deny); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, s := range cidrs {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("%w: invalid CIDR %q", ErrInvalidOption, s)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid CIDR %q", ErrInvalidOption, s)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// allowed reports whether conn passes the filter. The deny list takes precedence over the
+// allow list, and an empty allow list allows any address. Connections without a remote IP
+// address aren't filtered.
+func (f *ipFilter) allowed(conn net.Conn) bool {
+	ip := net.ParseIP(connIP(conn))
+	if ip == nil {
+		return true
+	}
+	if containsIP(f.deny, ip) {
+		return false
+	}
+	return len(f.allow) == 0 || containsIP(f.allow, ip)
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,538 @@
+package accepter
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// idleConn wraps net.Conn to extend the deadline by the timeout on each successful I/O.
+// The read and write deadlines are never extended beyond readLimit and writeLimit,
+// unless they are zero. If overridable, extending stops once a deadline is set on it.
+type idleConn struct {
+	net.Conn
+	timeout     time.Duration
+	readLimit   time.Time
+	writeLimit  time.Time
+	overridable bool
+	disabled    atomic.Bool
+}
+
+func newIdleConn(conn net.Conn, timeout time.Duration, readLimit, writeLimit time.Time, overridable bool) *idleConn {
+	c := &idleConn{
+		Conn:        conn,
+		timeout:     timeout,
+		readLimit:   readLimit,
+		writeLimit:  writeLimit,
+		overridable: overridable,
+	}
+	c.extend()
+	return c
+}
+
+func (c *idleConn) extend() {
+	if c.disabled.Load() {
+		return
+	}
+	t := time.Now().Add(c.timeout)
+	if c.readLimit.IsZero() && c.writeLimit.IsZero() {
+		c.Conn.SetDeadline(t)
+		return
+	}
+	c.Conn.SetReadDeadline(minTime(t, c.readLimit))
+	c.Conn.SetWriteDeadline(minTime(t, c.writeLimit))
+}
+
+// Read is implementation of net.Conn
+func (c *idleConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	if err == nil {
+		c.extend()
+	}
+	return
+}
+
+// Write is implementation of net.Conn
+func (c *idleConn) Write(b []byte) (n int, err error) {
+	n, err = c.Conn.Write(b)
+	if err == nil {
+		c.extend()
+	}
+	return
+}
+
+// writeTimeoutConn wraps net.Conn to set the write deadline by the timeout before each write.
+type writeTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+// Write is implementation of net.Conn
+func (c *writeTimeoutConn) Write(b []byte) (n int, err error) {
+	c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(b)
+}
+
+// WriteTo is implementation of io.WriterTo. It's forwarded to the underlying connection,
+// since the reads aren't affected by the write timeout.
+func (c *writeTimeoutConn) WriteTo(w io.Writer) (n int64, err error) {
+	return writeTo(c.Conn, w)
+}
+
+// SetDeadline is implementation of net.Conn
+func (c *idleConn) SetDeadline(t time.Time) error {
+	c.override()
+	return c.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline is implementation of net.Conn
+func (c *idleConn) SetReadDeadline(t time.Time) error {
+	c.override()
+	return c.Conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline is implementation of net.Conn
+func (c *idleConn) SetWriteDeadline(t time.Time) error {
+	c.override()
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// stop stops extending the deadlines, and restores them to readLimit and writeLimit, e.g.
+// when the connection is used bypassing the wrapper.
+func (c *idleConn) stop() {
+	c.disabled.Store(true)
+	c.Conn.SetReadDeadline(c.readLimit)
+	c.Conn.SetWriteDeadline(c.writeLimit)
+}
+
+func (c *idleConn) override() {
+	if c.overridable {
+		c.disabled.Store(true)
+	}
+}
+
+// minTime returns the earlier of t and limit. A zero limit means no limit.
+func minTime(t, limit time.Time) time.Time {
+	if !limit.IsZero() && limit.Before(t) {
+		return limit
+	}
+	return t
+}
+
+// detectCloseBufSize is the size of the buffers read ahead by detectCloseConn.
+const detectCloseBufSize = 4 * 1024
+
+// detectCloseConn wraps net.Conn to read ahead in a background goroutine, and to call cancel
+// as soon as reading fails with a non-timeout error, e.g. the peer closed the connection.
+// At most one buffer is read ahead, until it's consumed by Read. The background goroutine
+// exits after a non-timeout error or when done is closed. If pool isn't nil, the buffers are
+// taken from pool, and put back after consumed.
+//
+// After a timeout, the background goroutine pauses until a read deadline is set again, so it
+// doesn't read against the expired deadline. Setting a read deadline drops the timeouts of
+// the former deadlines that aren't returned by Read yet, like the background read of
+// net/http.
+type detectCloseConn struct {
+	net.Conn
+	cancel     context.CancelFunc
+	done       <-chan struct{}
+	pool       BufferPool
+	results    chan readResult
+	resume     chan struct{}
+	gen        atomic.Uint64
+	mu         sync.Mutex
+	buf        *[]byte
+	pending    []byte
+	pendingErr error
+	pendingGen uint64
+	err        error
+}
+
+// readResult is the result of a read by the background goroutine of detectCloseConn. gen is
+// the deadline generation when the read started.
+type readResult struct {
+	buf *[]byte
+	b   []byte
+	err error
+	gen uint64
+}
+
+func newDetectCloseConn(conn net.Conn, cancel context.CancelFunc, done <-chan struct{}, pool BufferPool) *detectCloseConn {
+	c := &detectCloseConn{
+		Conn:    conn,
+		cancel:  cancel,
+		done:    done,
+		pool:    pool,
+		results: make(chan readResult),
+		resume:  make(chan struct{}, 1),
+	}
+	go c.readLoop()
+	return c
+}
+
+func (c *detectCloseConn) getBuf() *[]byte {
+	if c.pool != nil {
+		if b := c.pool.Get(); b != nil && len(*b) > 0 {
+			return b
+		}
+	}
+	b := make([]byte, detectCloseBufSize)
+	return &b
+}
+
+func (c *detectCloseConn) putBuf(b *[]byte) {
+	if c.pool != nil && b != nil {
+		c.pool.Put(b)
+	}
+}
+
+func (c *detectCloseConn) readLoop() {
+	for {
+		gen := c.gen.Load()
+		b := c.getBuf()
+		n, err := c.Conn.Read(*b)
+		fatal := err != nil && !isTimeout(err)
+		if fatal {
+			c.cancel()
+		}
+		select {
+		case c.results <- readResult{buf: b, b: (*b)[:n], err: err, gen: gen}:
+		case <-c.done:
+			c.putBuf(b)
+			return
+		}
+		if fatal {
+			return
+		}
+		if err != nil && c.gen.Load() == gen {
+			select {
+			case <-c.resume:
+			case <-c.done:
+				return
+			}
+		}
+	}
+}
+
+// Read is implementation of net.Conn
+func (c *detectCloseConn) Read(b []byte) (n int, err error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		if len(c.pending) == 0 && c.pendingErr == nil {
+			if c.err != nil {
+				return 0, c.err
+			}
+			select {
+			case r := <-c.results:
+				c.buf, c.pending, c.pendingErr, c.pendingGen = r.buf, r.b, r.err, r.gen
+				c.release()
+			case <-c.done:
+				return 0, net.ErrClosed
+			}
+		}
+		if len(c.pending) > 0 {
+			n = copy(b, c.pending)
+			c.pending = c.pending[n:]
+			c.release()
+			return n, nil
+		}
+		err, c.pendingErr = c.pendingErr, nil
+		if !isTimeout(err) {
+			c.err = err
+			return 0, err
+		}
+		if c.pendingGen == c.gen.Load() {
+			return 0, err
+		}
+	}
+}
+
+// SetDeadline is implementation of net.Conn
+func (c *detectCloseConn) SetDeadline(t time.Time) error {
+	err := c.Conn.SetDeadline(t)
+	c.resumeRead()
+	return err
+}
+
+// SetReadDeadline is implementation of net.Conn
+func (c *detectCloseConn) SetReadDeadline(t time.Time) error {
+	err := c.Conn.SetReadDeadline(t)
+	c.resumeRead()
+	return err
+}
+
+// resumeRead starts a new deadline generation, so the pending timeouts are dropped, and
+// resumes the background goroutine if it's paused after a timeout. It must be called after
+// the deadline is set.
+func (c *detectCloseConn) resumeRead() {
+	c.gen.Add(1)
+	select {
+	case c.resume <- struct{}{}:
+	default:
+	}
+}
+
+// release puts the buffer of the pending data back to the pool after the data is consumed.
+// It must be called with c.mu locked.
+func (c *detectCloseConn) release() {
+	if len(c.pending) == 0 && c.buf != nil {
+		c.putBuf(c.buf)
+		c.buf = nil
+	}
+}
+
+// ReadFrom is implementation of io.ReaderFrom
+func (c *detectCloseConn) ReadFrom(r io.Reader) (n int64, err error) {
+	return readFrom(c.Conn, r)
+}
+
+// isTimeout reports whether err is a timeout error.
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+// byteCounter counts the bytes read from and written to a connection, and adds them to the
+// totals in stats.
+type byteCounter struct {
+	read    atomic.Uint64
+	written atomic.Uint64
+	stats   *stats
+}
+
+// countConn wraps net.Conn to count the bytes read and written by counter.
+type countConn struct {
+	net.Conn
+	counter *byteCounter
+}
+
+// Read is implementation of net.Conn
+func (c *countConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	if n > 0 {
+		c.counter.read.Add(uint64(n))
+		c.counter.stats.bytesRead.Add(uint64(n))
+	}
+	return
+}
+
+// Write is implementation of net.Conn
+func (c *countConn) Write(b []byte) (n int, err error) {
+	n, err = c.Conn.Write(b)
+	if n > 0 {
+		c.counter.written.Add(uint64(n))
+		c.counter.stats.bytesWritten.Add(uint64(n))
+	}
+	return
+}
+
+// ReadFrom is implementation of io.ReaderFrom. If the underlying connection implements
+// io.ReaderFrom, the bytes are counted after it returns.
+func (c *countConn) ReadFrom(r io.Reader) (n int64, err error) {
+	rf, ok := c.Conn.(io.ReaderFrom)
+	if !ok {
+		return io.Copy(writerOnly{c}, r)
+	}
+	n, err = rf.ReadFrom(r)
+	if n > 0 {
+		c.counter.written.Add(uint64(n))
+		c.counter.stats.bytesWritten.Add(uint64(n))
+	}
+	return
+}
+
+// WriteTo is implementation of io.WriterTo. If the underlying connection implements
+// io.WriterTo, the bytes are counted after it returns.
+func (c *countConn) WriteTo(w io.Writer) (n int64, err error) {
+	wt, ok := c.Conn.(io.WriterTo)
+	if !ok {
+		return io.Copy(w, readerOnly{c})
+	}
+	n, err = wt.WriteTo(w)
+	if n > 0 {
+		c.counter.read.Add(uint64(n))
+		c.counter.stats.bytesRead.Add(uint64(n))
+	}
+	return
+}
+
+// rateConn wraps net.Conn to limit the read and write rates by the limiters, counting the
+// bytes as tokens. Each Read is reduced to the smallest burst of readers, and it waits for
+// the tokens of the bytes read before returning, so the subsequent reads are paced. Write
+// splits b into chunks of the smallest burst of writers, and waits for the tokens of each
+// chunk before writing it. Waiting returns the ctx error if ctx is done before.
+type rateConn struct {
+	net.Conn
+	ctx     context.Context
+	readers []*RateLimiter
+	writers []*RateLimiter
+}
+
+// Read is implementation of net.Conn
+func (c *rateConn) Read(b []byte) (n int, err error) {
+	if len(c.readers) == 0 {
+		return c.Conn.Read(b)
+	}
+	if max := minBurst(c.readers); len(b) > max {
+		b = b[:max]
+	}
+	n, err = c.Conn.Read(b)
+	if n > 0 {
+		for _, l := range c.readers {
+			if e := l.WaitN(c.ctx, n); e != nil {
+				if err == nil {
+					err = e
+				}
+				break
+			}
+		}
+	}
+	return
+}
+
+// Write is implementation of net.Conn
+func (c *rateConn) Write(b []byte) (n int, err error) {
+	if len(c.writers) == 0 {
+		return c.Conn.Write(b)
+	}
+	max := minBurst(c.writers)
+	for len(b) > 0 {
+		p := b
+		if len(p) > max {
+			p = p[:max]
+		}
+		for _, l := range c.writers {
+			if err = l.WaitN(c.ctx, len(p)); err != nil {
+				return
+			}
+		}
+		var m int
+		m, err = c.Conn.Write(p)
+		n += m
+		if err != nil {
+			return
+		}
+		b = b[m:]
+	}
+	return
+}
+
+// ReadFrom is implementation of io.ReaderFrom. It's forwarded to the underlying connection
+// if the writes aren't limited.
+func (c *rateConn) ReadFrom(r io.Reader) (n int64, err error) {
+	if len(c.writers) == 0 {
+		return readFrom(c.Conn, r)
+	}
+	return io.Copy(writerOnly{c}, r)
+}
+
+// WriteTo is implementation of io.WriterTo. It's forwarded to the underlying connection if
+// the reads aren't limited.
+func (c *rateConn) WriteTo(w io.Writer) (n int64, err error) {
+	if len(c.readers) == 0 {
+		return writeTo(c.Conn, w)
+	}
+	return io.Copy(w, readerOnly{c})
+}
+
+// minBurst returns the smallest burst of the limiters.
+func minBurst(limiters []*RateLimiter) int {
+	min := 0
+	for _, l := range limiters {
+		if b := l.Burst(); min == 0 || b < min {
+			min = b
+		}
+	}
+	return min
+}
+
+// A PeekableConn is a net.Conn that can return the next bytes of the incoming data without
+// consuming them, so they are still returned by the subsequent Read calls.
+type PeekableConn interface {
+	net.Conn
+
+	// Peek returns the next n bytes without consuming them, blocking until they are
+	// available. If Peek returns fewer than n bytes, it also returns an error.
+	Peek(n int) ([]byte, error)
+
+	// Buffered returns the number of bytes that can be peeked without blocking.
+	Buffered() int
+}
+
+// peekConn wraps net.Conn to implement PeekableConn by a buffered reader.
+type peekConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func newPeekConn(conn net.Conn) *peekConn {
+	return &peekConn{
+		Conn: conn,
+		r:    bufio.NewReader(conn),
+	}
+}
+
+// Read is implementation of net.Conn
+func (c *peekConn) Read(b []byte) (n int, err error) {
+	return c.r.Read(b)
+}
+
+// ReadFrom is implementation of io.ReaderFrom
+func (c *peekConn) ReadFrom(r io.Reader) (n int64, err error) {
+	return readFrom(c.Conn, r)
+}
+
+// WriteTo is implementation of io.WriterTo
+func (c *peekConn) WriteTo(w io.Writer) (n int64, err error) {
+	return c.r.WriteTo(w)
+}
+
+// Peek is implementation of PeekableConn
+func (c *peekConn) Peek(n int) ([]byte, error) {
+	return c.r.Peek(n)
+}
+
+// Buffered is implementation of PeekableConn
+func (c *peekConn) Buffered() int {
+	return c.r.Buffered()
+}
+
+// readFrom reads from r and writes to conn until EOF or error. It's forwarded to the
+// io.ReaderFrom of conn if implemented, so the fast paths like sendfile of *net.TCPConn are
+// preserved through the wrapping connections.
+func readFrom(conn net.Conn, r io.Reader) (int64, error) {
+	if rf, ok := conn.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(writerOnly{conn}, r)
+}
+
+// writeTo reads from conn and writes to w until EOF or error. It's forwarded to the
+// io.WriterTo of conn if implemented, so the fast paths like splice of *net.TCPConn are
+// preserved through the wrapping connections.
+func writeTo(conn net.Conn, w io.Writer) (int64, error) {
+	if wt, ok := conn.(io.WriterTo); ok {
+		return wt.WriteTo(w)
+	}
+	return io.Copy(w, readerOnly{conn})
+}
+
+// writerOnly hides the methods of an io.Writer other than Write, so that io.Copy doesn't
+// call back the ReadFrom method of the wrapping connection.
+type writerOnly struct {
+	io.Writer
+}
+
+// readerOnly hides the methods of an io.Reader other than Read, so that io.Copy doesn't
+// call back the WriteTo method of the wrapping connection.
+type readerOnly struct {
+	io.Reader
+}
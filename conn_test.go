@@ -0,0 +1,187 @@
+package accepter
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDetectCloseDeadlineAfterTimeout(t *testing.T) {
+	send := make(chan struct{})
+	result := make(chan error, 1)
+	a := &Accepter{
+		DetectClose: true,
+		Handler: HandlerFunc(func(ctx context.Context, conn net.Conn) {
+			b := make([]byte, 2)
+			conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+			if _, err := conn.Read(b); !isTimeout(err) {
+				result <- err
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			close(send)
+			if _, err := conn.Read(b); err != nil {
+				result <- err
+				return
+			}
+			if string(b) != "hi" {
+				t.Errorf("read %q", b)
+			}
+			result <- nil
+		}),
+	}
+	addr, errc := serveTCP(t, a)
+
+	conn := dial(t, addr)
+	defer conn.Close()
+	<-send
+	if _, err := conn.Write([]byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-result; err != nil {
+		t.Fatalf("Read returned %v", err)
+	}
+
+	shutdown(t, a, errc)
+}
+
+func TestIdleTimeoutRead(t *testing.T) {
+	result := make(chan error, 1)
+	a := &Accepter{
+		IdleTimeout: 100 * time.Millisecond,
+		Handler: HandlerFunc(func(ctx context.Context, conn net.Conn) {
+			b := make([]byte, 1)
+			if _, err := conn.Read(b); err != nil {
+				result <- err
+				return
+			}
+			start := time.Now()
+			_, err := conn.Read(b)
+			if d := time.Since(start); d > time.Second {
+				t.Errorf("idle read is unblocked after %v", d)
+			}
+			result <- err
+		}),
+	}
+	addr, errc := serveTCP(t, a)
+
+	conn := dial(t, addr)
+	defer conn.Close()
+	if _, err := conn.Write([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-result; !isTimeout(err) {
+		t.Errorf("idle read returned %v", err)
+	}
+
+	shutdown(t, a, errc)
+}
+
+// writerToConn is a net.Conn that records whether its WriteTo method is called.
+type writerToConn struct {
+	net.Conn
+	called bool
+}
+
+func (c *writerToConn) WriteTo(w io.Writer) (int64, error) {
+	c.called = true
+	return 0, nil
+}
+
+func TestWriteTimeoutConnWriteTo(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	c2.Close()
+	inner := &writerToConn{Conn: c1}
+	conn := &writeTimeoutConn{
+		Conn:    inner,
+		timeout: time.Second,
+	}
+	io.Copy(io.Discard, conn)
+	if !inner.called {
+		t.Error("WriteTo isn't forwarded to the underlying connection")
+	}
+}
+
+// BenchmarkSendFile sends a file to the client by io.Copy through the wrapping connections
+// of the Accepter. Through ReadFrom, *net.TCPConn sends the file by sendfile where
+// supported, so io.Copy doesn't allocate its buffer. With ReadFrom hidden, the file is
+// copied through a buffer allocated by each io.Copy.
+func BenchmarkSendFile(b *testing.B) {
+	data := make([]byte, 1<<20)
+	name := filepath.Join(b.TempDir(), "data")
+	if err := os.WriteFile(name, data, 0600); err != nil {
+		b.Fatal(err)
+	}
+	for _, bb := range []struct {
+		name string
+		dst  func(conn net.Conn) io.Writer
+	}{
+		{"ReadFrom", func(conn net.Conn) io.Writer { return conn }},
+		{"Hidden", func(conn net.Conn) io.Writer { return struct{ io.Writer }{conn} }},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			a := &Accepter{
+				CountBytes:   true,
+				Peekable:     true,
+				ReadTimeout:  time.Minute,
+				WriteTimeout: time.Minute,
+				Handler: HandlerFunc(func(ctx context.Context, conn net.Conn) {
+					f, err := os.Open(name)
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					defer f.Close()
+					req := make([]byte, 1)
+					for {
+						if _, err := conn.Read(req); err != nil {
+							return
+						}
+						f.Seek(0, io.SeekStart)
+						if _, err := io.Copy(bb.dst(conn), f); err != nil {
+							b.Error(err)
+							return
+						}
+					}
+				}),
+			}
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				b.Fatal(err)
+			}
+			errc := make(chan error, 1)
+			go func() {
+				errc <- a.Serve(lis)
+			}()
+			conn, err := net.Dial("tcp", lis.Addr().String())
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer conn.Close()
+
+			buf := make([]byte, len(data))
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := conn.Write([]byte{0}); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.ReadFull(conn, buf); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+
+			conn.Close()
+			a.Shutdown(context.Background())
+			<-errc
+		})
+	}
+}
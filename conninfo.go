@@ -0,0 +1,71 @@
+package accepter
+
+import (
+	"net"
+	"sort"
+	"time"
+)
+
+// ConnInfo is a snapshot of the information of a connection being served.
+type ConnInfo struct {
+	// ConnID is the ID of the connection, as returned by ConnID.
+	ConnID uint64
+
+	// RemoteAddr is the effective remote address of the connection. It's nil until the
+	// PROXY protocol header is read, if ProxyProtocol is set.
+	RemoteAddr net.Addr
+
+	// LocalAddr is the effective local address of the connection, like RemoteAddr.
+	LocalAddr net.Addr
+
+	// StartedAt is the time when the connection was accepted.
+	StartedAt time.Time
+
+	// BytesRead is the number of bytes read from the connection so far. It's counted only
+	// if CountBytes is set.
+	BytesRead uint64
+
+	// BytesWritten is the number of bytes written to the connection so far. It's counted
+	// only if CountBytes is set.
+	BytesWritten uint64
+}
+
+// Connections returns a snapshot of the connections being served, ordered by ConnID.
+// It's safe to call concurrently.
+func (a *Accepter) Connections() []ConnInfo {
+	a.connsMu.RLock()
+	infos := make([]ConnInfo, 0, len(a.conns))
+	for _, cd := range a.conns {
+		info := ConnInfo{
+			ConnID:     cd.id,
+			RemoteAddr: cd.remoteAddr,
+			LocalAddr:  cd.localAddr,
+			StartedAt:  cd.startedAt,
+		}
+		if cd.bytes != nil {
+			info.BytesRead = cd.bytes.read.Load()
+			info.BytesWritten = cd.bytes.written.Load()
+		}
+		infos = append(infos, info)
+	}
+	a.connsMu.RUnlock()
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ConnID < infos[j].ConnID
+	})
+	return infos
+}
+
+// CloseConn closes the connection with the given ID after cancelling its context, like Close
+// does for all of the connections. It returns false if no connection with the ID is being
+// served. It's safe to call concurrently.
+func (a *Accepter) CloseConn(id uint64) bool {
+	a.connsMu.RLock()
+	defer a.connsMu.RUnlock()
+	for conn, cd := range a.conns {
+		if cd.id == id {
+			cd.close(conn)
+			return true
+		}
+	}
+	return false
+}
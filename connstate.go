@@ -0,0 +1,49 @@
+package accepter
+
+import (
+	"net"
+)
+
+// A ConnState represents the state of a connection. It's used by the optional
+// Accepter.ConnState hook.
+type ConnState int
+
+const (
+	// StateNew represents a new connection that has been accepted, but
+	// the handler hasn't been invoked yet.
+	StateNew ConnState = iota
+
+	// StateActive represents a connection that is being served by the handler.
+	StateActive
+
+	// StateIdle represents a connection that is idle. The Accepter doesn't
+	// observe connection I/O, so it never reports StateIdle by itself.
+	StateIdle
+
+	// StateClosed represents a connection that is about to be closed after
+	// the handler returned. This is a terminal state.
+	StateClosed
+
+	// StateHijacked represents a connection that has been hijacked by Hijack.
+	// The Accepter doesn't track or close it anymore. This is a terminal state.
+	StateHijacked
+)
+
+var stateName = map[ConnState]string{
+	StateNew:      "new",
+	StateActive:   "active",
+	StateIdle:     "idle",
+	StateClosed:   "closed",
+	StateHijacked: "hijacked",
+}
+
+// String is implementation of fmt.Stringer
+func (c ConnState) String() string {
+	return stateName[c]
+}
+
+func (a *Accepter) setState(conn net.Conn, state ConnState) {
+	if hook := a.ConnState; hook != nil {
+		hook(conn, state)
+	}
+}
@@ -0,0 +1,128 @@
+package accepter
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+)
+
+// contextKey is the type of the context keys of the package. Since it's unexported, the
+// keys never collide with the ones of other packages or handlers, even with the same
+// underlying value.
+//
+// The values of the keys are set to each connection context before ConnContext is called,
+// and they're immutable for the lifetime of the connection context, which ends when the
+// handler returns. The accessor functions return the zero values for the contexts without
+// them, so the handlers can be tested with synthetic contexts.
+type contextKey int
+
+const (
+	// TLSStateContextKey is the context key of the connection state of a TLS connection.
+	// It's set to the connection context after the TLS handshake, and the associated value
+	// is of type tls.ConnectionState.
+	TLSStateContextKey contextKey = iota
+
+	// BytesContextKey is the context key of the byte counts of a connection. It's set to the
+	// connection context if CountBytes is set, and the counts keep increasing while the
+	// connection is used. Use ConnBytes to get the counts.
+	BytesContextKey
+
+	// HijackContextKey is the context key of the hijacker of a connection. It's set to the
+	// connection context unless DetectClose is set. Use Hijack to take over the connection.
+	HijackContextKey
+
+	// RemoteAddrContextKey is the context key of the effective remote address of a connection.
+	// It's set to the connection context before ConnContext is called, and the associated value
+	// is of type net.Addr. If ProxyProtocol is set, it's the source address in the PROXY
+	// protocol header.
+	RemoteAddrContextKey
+
+	// LocalAddrContextKey is the context key of the effective local address of a connection.
+	// It's set like RemoteAddrContextKey, and the associated value is of type net.Addr.
+	LocalAddrContextKey
+
+	// ConnIDContextKey is the context key of the ID of a connection. It's set to the connection
+	// context first, and the associated value is of type uint64. Use ConnID to get the ID.
+	ConnIDContextKey
+
+	// ShutdownContextKey is the context key of the serving context of the Accepter. It's set
+	// to the connection context with ConnIDContextKey, and the associated value is of type context.Context, which
+	// is cancelled when serving stops, e.g. on Shutdown or Close, but not when the connection
+	// completes. Use ShuttingDown to check it.
+	ShutdownContextKey
+
+	// LoggerContextKey is the context key of the logger of a connection. It's set to the
+	// connection context before ConnContext is called if Logger is set, and the associated
+	// value is of type Logger. Use ConnLogger to get the logger.
+	LoggerContextKey
+)
+
+// TLSState returns the connection state of the TLS connection from the connection context ctx.
+// It returns false if the connection isn't a TLS connection.
+func TLSState(ctx context.Context) (tls.ConnectionState, bool) {
+	state, ok := ctx.Value(TLSStateContextKey).(tls.ConnectionState)
+	return state, ok
+}
+
+// IsTLS reports whether the connection of the connection context ctx is a TLS connection,
+// e.g. served by ServeTLS. It's true if and only if TLSState returns true.
+func IsTLS(ctx context.Context) bool {
+	_, ok := TLSState(ctx)
+	return ok
+}
+
+// RemoteAddr returns the effective remote address of the connection from the connection
+// context ctx, or nil if it isn't set.
+func RemoteAddr(ctx context.Context) net.Addr {
+	addr, _ := ctx.Value(RemoteAddrContextKey).(net.Addr)
+	return addr
+}
+
+// LocalAddr returns the effective local address of the connection from the connection
+// context ctx, or nil if it isn't set.
+func LocalAddr(ctx context.Context) net.Addr {
+	addr, _ := ctx.Value(LocalAddrContextKey).(net.Addr)
+	return addr
+}
+
+// ConnID returns the ID of the connection from the connection context ctx, or zero if it
+// isn't set. It's the same ID returned by Accepter.ConnID.
+func ConnID(ctx context.Context) uint64 {
+	id, _ := ctx.Value(ConnIDContextKey).(uint64)
+	return id
+}
+
+// ShuttingDown reports whether the Accepter serving the connection of the connection
+// context ctx is shutting down, e.g. Shutdown or Close has been called. It distinguishes
+// the shutdown from the other reasons of the cancellation of ctx, like the peer closing the
+// connection, so the handler can send a final message before returning.
+//
+// On Shutdown, the listeners are closed and the connection contexts are cancelled, and
+// ShuttingDown reports true from then. The handlers may still write to their connections until
+// DrainTimeout expires or the context given to Shutdown is done, and then the connections
+// are closed after their contexts are cancelled. On Close, the connections are closed right
+// after their contexts are cancelled, so the handlers may not be able to write anymore.
+func ShuttingDown(ctx context.Context) bool {
+	sctx, ok := ctx.Value(ShutdownContextKey).(context.Context)
+	return ok && sctx.Err() != nil
+}
+
+// ConnBytes returns the number of bytes read from and written to the connection so far, from the
+// connection context ctx. It returns false if the bytes aren't counted.
+func ConnBytes(ctx context.Context) (read, written uint64, ok bool) {
+	bc, ok := ctx.Value(BytesContextKey).(*byteCounter)
+	if !ok {
+		return 0, 0, false
+	}
+	return bc.read.Load(), bc.written.Load(), true
+}
+
+// ConnLogger returns the logger of the connection from the connection context ctx, which
+// prefixes the messages with the connection ID and the remote address. It returns a logger
+// discarding the messages if Logger isn't set.
+func ConnLogger(ctx context.Context) Logger {
+	if l, ok := ctx.Value(LoggerContextKey).(Logger); ok {
+		return l
+	}
+	return nopLogger{}
+}
@@ -0,0 +1,55 @@
+package accepter
+
+import (
+	"net"
+)
+
+// errorsBufSize is the buffer size of the channel returned by Errors.
+const errorsBufSize = 64
+
+// Errors returns a channel that delivers the non-fatal errors of accepting, e.g. temporary
+// errors that are retried, and a RejectError for each rejected connection. The errors are
+// dropped while the channel is full, so accepting is never blocked. The fatal errors are
+// returned by Serve instead. The channel is never closed, and it's the same for the
+// lifetime of the Accepter.
+func (a *Accepter) Errors() <-chan error {
+	a.connsMu.Lock()
+	defer a.connsMu.Unlock()
+	if a.errs == nil {
+		a.errs = make(chan error, errorsBufSize)
+	}
+	return a.errs
+}
+
+// reportError sends err to the channel returned by Errors if it has been created, unless the
+// channel is full.
+func (a *Accepter) reportError(err error) {
+	a.connsMu.RLock()
+	errs := a.errs
+	a.connsMu.RUnlock()
+	if errs == nil {
+		return
+	}
+	select {
+	case errs <- err:
+	default:
+	}
+}
+
+// reportReject reports a RejectError for conn with reason.
+func (a *Accepter) reportReject(conn net.Conn, reason string) {
+	a.connsMu.RLock()
+	errs := a.errs
+	a.connsMu.RUnlock()
+	if errs == nil {
+		return
+	}
+	addr := conn.RemoteAddr
+	if pc, ok := conn.(*proxyConn); ok {
+		addr = pc.Conn.RemoteAddr
+	}
+	a.reportError(&RejectError{
+		Addr:   addr(),
+		Reason: reason,
+	})
+}
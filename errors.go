@@ -3,13 +3,59 @@ package accepter
 import (
 	"errors"
 	"fmt"
+	"net"
 )
 
 var (
-	// ErrAlreadyServed is returned when Serve or ServeTLS method has been already called
+	// ErrAlreadyServed is returned when Serve or ServeTLS method is called while the accepter is still serving
 	ErrAlreadyServed = errors.New("the accepter has already served")
+
+	// ErrServerClosed is returned by Serve, ServeTLS, ServePacket and the listening methods after Close or Shutdown method called
+	ErrServerClosed = errors.New("accepter: Server closed")
+
+	// ErrNotServing is returned when Shutdown or Close method has been called before Serve or ServeTLS method
+	ErrNotServing = errors.New("the accepter is not serving")
+
+	// ErrInvalidProxyHeader is returned when reading from a connection with a malformed PROXY protocol header
+	ErrInvalidProxyHeader = errors.New("invalid proxy protocol header")
+
+	// ErrReusePortUnsupported is returned when listening with ReusePort on a platform without SO_REUSEPORT
+	ErrReusePortUnsupported = errors.New("reuse port is unsupported on this platform")
+
+	// ErrInvalidOption is wrapped by the error returned when NewAccepter gets an invalid Option, or serving starts with an invalid field
+	ErrInvalidOption = errors.New("invalid option")
+
+	// ErrListenerFileUnsupported is returned by ListenerFile when the underlying Listener doesn't provide its file
+	ErrListenerFileUnsupported = errors.New("listener file is unsupported")
+
+	// ErrNotHijackable is returned by Hijack when the context doesn't belong to a hijackable connection
+	ErrNotHijackable = errors.New("the connection is not hijackable")
+
+	// ErrHijacked is returned by Hijack when the connection has already been hijacked
+	ErrHijacked = errors.New("the connection has already been hijacked")
+
+	// ErrShutdownTimeout is wrapped with the context's error by the error returned from Shutdown when the context expires before the connections are drained
+	ErrShutdownTimeout = errors.New("accepter: Shutdown timed out")
+
+	errLimitExceeded = errors.New("connection limit exceeded")
 )
 
+// RejectError is delivered by the channel returned by Errors when an accepted connection
+// is rejected before serving.
+type RejectError struct {
+	// Addr is the remote address of the connection. If ProxyProtocol is set, it's the
+	// address of the proxy.
+	Addr net.Addr
+
+	// Reason describes why the connection is rejected.
+	Reason string
+}
+
+// Error is implementation of error
+func (e *RejectError) Error() string {
+	return fmt.Sprintf("connection from %v rejected: %s", e.Addr, e.Reason)
+}
+
 // TLSError is returned when a method fails with TLS error
 type TLSError struct {
 	err error
@@ -1,9 +1,11 @@
+//go:build ignore
 // +build ignore
 
 package main
 
 import (
 	"context"
+	"io"
 	"log"
 	"net"
 
@@ -11,22 +13,11 @@ import (
 )
 
 func main() {
+	pool := accepter.NewBufferPool(32 * 1024)
 	a := &accepter.Accepter{
 		Handler: accepter.HandlerFunc(func(ctx context.Context, conn net.Conn) {
-			for {
-				var b [32 * 1024]byte
-				n, err := conn.Read(b[:])
-				if err != nil {
-					break
-				}
-				m, err := conn.Write(b[:n])
-				if err != nil {
-					break
-				}
-				if m < n {
-					break
-				}
-			}
+			// hide ReadFrom and WriteTo of conn, otherwise io.CopyBuffer doesn't use the buffer
+			accepter.CopyBuffer(struct{ io.Writer }{conn}, struct{ io.Reader }{conn}, pool)
 		}),
 	}
 	log.Fatal(a.ListenAndServe("tcp", ":1234"))
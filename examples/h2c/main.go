@@ -0,0 +1,25 @@
+//go:build ignore
+// +build ignore
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/goinsane/accepter"
+)
+
+func main() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello over %s\n", r.Proto)
+	})
+	a := &accepter.Accepter{
+		Handler:        accepter.H2CHandler(mux),
+		MaxConnections: 1000,
+	}
+	// try with: curl --http2-prior-knowledge http://localhost:1234/
+	log.Fatal(a.ListenAndServe("tcp", ":1234"))
+}
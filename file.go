@@ -0,0 +1,48 @@
+package accepter
+
+import (
+	"net"
+	"os"
+)
+
+// ServeFile accepts incoming connections on the Listener created from the file f
+// by net.FileListener, like Serve. It's intended for serving on a listening socket
+// inherited from another process, e.g. by exec.Cmd's ExtraFiles. ServeFile
+// doesn't close f, so the caller should close it after ServeFile started.
+func (a *Accepter) ServeFile(f *os.File) error {
+	lis, err := net.FileListener(f)
+	if err != nil {
+		return err
+	}
+	return a.Serve(lis)
+}
+
+// ListenerFile returns a duplicate of the file of the Accepter's underlying Listener,
+// to pass the listening socket to another process for a graceful restart. When
+// serving by ServeMany, it returns the file of the first Listener. The caller is
+// responsible for closing the returned file.
+//
+// The old process must keep serving until the new one is ready to accept by
+// ServeFile, after that the old process may call Shutdown. Connections in the
+// listen backlog are accepted by either of the processes meanwhile.
+//
+// ListenerFile returns ErrNotServing if the Accepter isn't serving on a Listener,
+// and ErrListenerFileUnsupported if the Listener doesn't provide its file.
+func (a *Accepter) ListenerFile() (*os.File, error) {
+	a.mu.RLock()
+	var lis net.Listener
+	if len(a.rawListeners) > 0 {
+		lis = a.rawListeners[0]
+	}
+	a.mu.RUnlock()
+	if lis == nil {
+		return nil, ErrNotServing
+	}
+	fl, ok := lis.(interface {
+		File() (*os.File, error)
+	})
+	if !ok {
+		return nil, ErrListenerFileUnsupported
+	}
+	return fl.File()
+}
@@ -1,3 +1,5 @@
 module github.com/goinsane/accepter
 
-go 1.1
+go 1.24.0
+
+require golang.org/x/sync v0.19.0
@@ -0,0 +1,34 @@
+package accepter
+
+import (
+	"context"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// RunGroup listens on the Network and Address of each Accepter, and serves them concurrently
+// by ListenAndServeContext until ctx is done or any of them fails. Then all of them are shut
+// down. Each Accepter is shut down with its own ShutdownTimeout as the grace timeout, and
+// the connections still open after it are closed; zero ShutdownTimeout means waiting for
+// the connections indefinitely. An Accepter stopped without failing, e.g. by its own
+// Shutdown, doesn't stop the others.
+//
+// RunGroup returns after all Accepters stop, with the first error other than
+// ErrServerClosed, e.g. a listening error or an error wrapping ErrShutdownTimeout, or nil
+// if all of them are shut down cleanly.
+func RunGroup(ctx context.Context, accepters ...*Accepter) error {
+	g, ctx := errgroup.WithContext(ctx)
+	for _, a := range accepters {
+		g.Go(func() error {
+			network := a.Network
+			if network == "" {
+				network = "tcp"
+			}
+			if err := a.ListenAndServeContext(ctx, network, a.Address); err != ErrServerClosed {
+				return err
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
@@ -0,0 +1,71 @@
+package accepter
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRunGroup(t *testing.T) {
+	served := make(chan net.Addr, 2)
+	newAccepter := func() *Accepter {
+		return &Accepter{
+			Address: "127.0.0.1:0",
+			OnServe: func(lis net.Listener) {
+				served <- lis.Addr()
+			},
+			Handler: HandlerFunc(func(ctx context.Context, conn net.Conn) {
+				conn.Write([]byte("a"))
+			}),
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- RunGroup(ctx, newAccepter(), newAccepter())
+	}()
+
+	for i := 0; i < 2; i++ {
+		conn := dial(t, (<-served).String())
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err != nil {
+			t.Error(err)
+		}
+		conn.Close()
+	}
+	cancel()
+	if err := <-errc; err != nil {
+		t.Fatalf("RunGroup returned %v", err)
+	}
+}
+
+func TestRunGroupListenError(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	a := &Accepter{
+		Address: "127.0.0.1:0",
+		Handler: HandlerFunc(func(ctx context.Context, conn net.Conn) {}),
+	}
+	b := &Accepter{
+		Address: lis.Addr().String(),
+		Handler: HandlerFunc(func(ctx context.Context, conn net.Conn) {}),
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- RunGroup(context.Background(), a, b)
+	}()
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Fatal("RunGroup returned nil")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunGroup doesn't return after a listening error")
+	}
+}
@@ -19,3 +19,33 @@ type HandlerFunc func(ctx context.Context, conn net.Conn)
 func (f HandlerFunc) Serve(ctx context.Context, conn net.Conn) {
 	f(ctx, conn)
 }
+
+// An ErrorHandler responds to an incoming connection, and returns an error if serving fails.
+type ErrorHandler interface {
+	Serve(ctx context.Context, conn net.Conn) error
+}
+
+// The ErrorHandlerFunc type is an adapter to allow the use of ordinary functions as
+// error handlers. If f is a function with the appropriate signature, ErrorHandlerFunc(f)
+// is an ErrorHandler that calls f.
+type ErrorHandlerFunc func(ctx context.Context, conn net.Conn) error
+
+// Serve calls f(ctx, conn)
+func (f ErrorHandlerFunc) Serve(ctx context.Context, conn net.Conn) error {
+	return f(ctx, conn)
+}
+
+// A PacketHandler responds to an incoming packet.
+type PacketHandler interface {
+	ServePacket(ctx context.Context, pc net.PacketConn, addr net.Addr, data []byte)
+}
+
+// The PacketHandlerFunc type is an adapter to allow the use of ordinary functions as
+// packet handlers. If f is a function with the appropriate signature, PacketHandlerFunc(f)
+// is a PacketHandler that calls f.
+type PacketHandlerFunc func(ctx context.Context, pc net.PacketConn, addr net.Addr, data []byte)
+
+// ServePacket calls f(ctx, pc, addr, data)
+func (f PacketHandlerFunc) ServePacket(ctx context.Context, pc net.PacketConn, addr net.Addr, data []byte) {
+	f(ctx, pc, addr, data)
+}
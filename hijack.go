@@ -0,0 +1,53 @@
+package accepter
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// hijacker transfers the ownership of a connection being served to its handler.
+type hijacker struct {
+	a        *Accepter
+	conn     net.Conn
+	c        net.Conn
+	mu       sync.Mutex
+	hijacked bool
+}
+
+func (h *hijacker) hijack() (net.Conn, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.hijacked {
+		return nil, ErrHijacked
+	}
+	h.hijacked = true
+	h.a.setState(h.conn, StateHijacked)
+	h.a.untrackConn(h.conn, true)
+	return h.c, nil
+}
+
+func (h *hijacker) isHijacked() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.hijacked
+}
+
+// Hijack takes over the connection of the connection context ctx, e.g. to hand it to another
+// subsystem after a protocol upgrade. It returns the connection as given to the handler.
+// After Hijack, the Accepter stops tracking the connection, and doesn't close it when the
+// handler returns, so the caller is responsible for closing it. Shutdown and Close don't
+// affect the hijacked connection, but the connection context is still cancelled when the
+// handler returns.
+//
+// The deadlines, IdleTimeout and the rate limits set by the Accepter remain in effect on
+// the returned connection. Hijack returns ErrNotHijackable if ctx isn't a connection
+// context or DetectClose is set, and ErrHijacked if the connection has already been
+// hijacked.
+func Hijack(ctx context.Context) (net.Conn, error) {
+	h, ok := ctx.Value(HijackContextKey).(*hijacker)
+	if !ok {
+		return nil, ErrNotHijackable
+	}
+	return h.hijack()
+}
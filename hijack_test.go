@@ -0,0 +1,110 @@
+package accepter
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// serveHijacked serves by a, and calls f in a new goroutine with each connection hijacked
+// by the handler, after the handler returns.
+func serveHijacked(t *testing.T, a *Accepter, f func(conn net.Conn)) (string, <-chan error) {
+	t.Helper()
+	a.Handler = HandlerFunc(func(ctx context.Context, conn net.Conn) {
+		hc, err := Hijack(ctx)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		go func() {
+			<-ctx.Done()
+			f(hc)
+		}()
+	})
+	return serveTCP(t, a)
+}
+
+func TestHijackReadBytesPerSec(t *testing.T) {
+	result := make(chan error, 1)
+	var elapsed time.Duration
+	a := &Accepter{
+		ReadBytesPerSec: 1000,
+	}
+	addr, errc := serveHijacked(t, a, func(conn net.Conn) {
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		start := time.Now()
+		b := make([]byte, 1500)
+		for len(b) > 0 {
+			n, err := conn.Read(b)
+			if err != nil {
+				result <- err
+				return
+			}
+			b = b[n:]
+		}
+		elapsed = time.Since(start)
+		result <- nil
+	})
+
+	conn := dial(t, addr)
+	defer conn.Close()
+	if _, err := conn.Write(make([]byte, 1500)); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-result; err != nil {
+		t.Fatalf("hijacked read returned %v", err)
+	}
+	// the burst is 1000 bytes, and the rest takes 500ms
+	if elapsed < 400*time.Millisecond {
+		t.Errorf("hijacked read isn't throttled: 1500 bytes read in %v", elapsed)
+	}
+
+	shutdown(t, a, errc)
+}
+
+func TestHijackWriteBytesPerSec(t *testing.T) {
+	result := make(chan error, 1)
+	var elapsed time.Duration
+	a := &Accepter{
+		WriteBytesPerSec: 1000,
+	}
+	addr, errc := serveHijacked(t, a, func(conn net.Conn) {
+		defer conn.Close()
+		start := time.Now()
+		_, err := conn.Write(make([]byte, 1500))
+		elapsed = time.Since(start)
+		result <- err
+	})
+
+	conn := dial(t, addr)
+	defer conn.Close()
+	if err := <-result; err != nil {
+		t.Fatalf("hijacked write returned %v", err)
+	}
+	// the burst is 1000 bytes, and the rest takes 500ms
+	if elapsed < 400*time.Millisecond {
+		t.Errorf("hijacked write isn't throttled: 1500 bytes written in %v", elapsed)
+	}
+
+	shutdown(t, a, errc)
+}
+
+func TestHijackStats(t *testing.T) {
+	hijacked := make(chan net.Conn, 1)
+	a := &Accepter{}
+	addr, errc := serveHijacked(t, a, func(conn net.Conn) {
+		hijacked <- conn
+	})
+
+	conn := dial(t, addr)
+	defer conn.Close()
+	hc := <-hijacked
+	defer hc.Close()
+
+	shutdown(t, a, errc)
+	if s := a.Stats(); s.TotalHijacked != 1 || s.TotalClosed != 0 {
+		t.Errorf("Stats returned %+v", s)
+	}
+}
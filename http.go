@@ -0,0 +1,147 @@
+package accepter
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// HTTPHandler returns a Handler that serves HTTP by h on each connection, like an http.Server
+// does for its connections. HTTP/1 keep-alive is handled by the http.Server, so multiple
+// requests may be served on a connection until the client or the handler closes it, or
+// the connection context is cancelled. If serving by ServeTLS, HTTP/2 is also negotiated
+// by ALPN when the connection given to the handler is the *tls.Conn itself, i.e. none of
+// the wrapping options is set.
+//
+// Each connection is served by its own http.Server, so the connection tracking, limits,
+// timeouts and PROXY protocol parsing of the Accepter apply. The request contexts carry
+// the values of the connection context, but they aren't cancelled with it. When the
+// connection context is cancelled, e.g. on Shutdown, the idle connection is closed, or the
+// active request completes first and then the connection is closed. The request context
+// is cancelled when the connection is closed, e.g. by Close. The connections hijacked by
+// the http.Hijacker are hijacked from the Accepter as well by Hijack, unless DetectClose
+// is set.
+func HTTPHandler(h http.Handler) Handler {
+	return &httpHandler{
+		handler: h,
+	}
+}
+
+// H2CHandler returns a Handler that serves HTTP/2 cleartext (h2c) with prior knowledge by h
+// on each connection, e.g. for gRPC without TLS. It also serves HTTP/1 on the connections
+// that don't start with the HTTP/2 connection preface. Upgrading from HTTP/1 by the
+// "Upgrade: h2c" header isn't supported.
+//
+// The connections are served like HTTPHandler, except the protocols.
+func H2CHandler(h http.Handler) Handler {
+	p := new(http.Protocols)
+	p.SetHTTP1(true)
+	p.SetUnencryptedHTTP2(true)
+	return &httpHandler{
+		handler:   h,
+		protocols: p,
+	}
+}
+
+// httpHandler is a Handler that serves HTTP by a http.Server on each connection.
+type httpHandler struct {
+	handler   http.Handler
+	protocols *http.Protocols
+}
+
+// Serve is implementation of Handler
+func (h *httpHandler) Serve(ctx context.Context, conn net.Conn) {
+	lis := newConnListener(conn)
+	srv := &http.Server{
+		Handler:   h.handler,
+		Protocols: h.protocols,
+		BaseContext: func(net.Listener) context.Context {
+			return context.WithoutCancel(ctx)
+		},
+		ConnState: func(c net.Conn, state http.ConnState) {
+			switch state {
+			case http.StateHijacked:
+				Hijack(ctx)
+				lis.finish()
+			case http.StateClosed:
+				lis.finish()
+			}
+		},
+	}
+
+	stop := make(chan struct{})
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		select {
+		case <-ctx.Done():
+			srv.Shutdown(context.Background())
+		case <-stop:
+		}
+	}()
+
+	srv.Serve(lis)
+	// Serve returns as soon as Shutdown closes the Listener, so wait for the active request
+	select {
+	case <-lis.done:
+	case <-shutdown:
+	}
+	close(stop)
+}
+
+// connListener is a Listener that accepts a single connection once, and then blocks
+// accepting until the connection is finished or the Listener is closed.
+type connListener struct {
+	conn      net.Conn
+	mu        sync.Mutex
+	accepted  bool
+	done      chan struct{}
+	closed    chan struct{}
+	doneOnce  sync.Once
+	closeOnce sync.Once
+}
+
+func newConnListener(conn net.Conn) *connListener {
+	return &connListener{
+		conn:   conn,
+		done:   make(chan struct{}),
+		closed: make(chan struct{}),
+	}
+}
+
+// Accept is implementation of net.Listener
+func (l *connListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	if !l.accepted {
+		l.accepted = true
+		l.mu.Unlock()
+		return l.conn, nil
+	}
+	l.mu.Unlock()
+	select {
+	case <-l.done:
+	case <-l.closed:
+	}
+	return nil, net.ErrClosed
+}
+
+// Close is implementation of net.Listener
+func (l *connListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+	return nil
+}
+
+// Addr is implementation of net.Listener
+func (l *connListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// finish marks the connection as finished, i.e. closed or hijacked.
+func (l *connListener) finish() {
+	l.doneOnce.Do(func() {
+		close(l.done)
+	})
+}
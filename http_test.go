@@ -0,0 +1,45 @@
+package accepter
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHTTPHandlerShutdownActiveRequest(t *testing.T) {
+	started := make(chan struct{})
+	a := &Accepter{
+		Handler: HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			time.Sleep(100 * time.Millisecond)
+			if err := r.Context().Err(); err != nil {
+				t.Errorf("request context is done: %v", err)
+			}
+			io.WriteString(w, "ok")
+		})),
+	}
+	addr, errc := serveTCP(t, a)
+
+	type result struct {
+		body string
+		err  error
+	}
+	resc := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr)
+		if err != nil {
+			resc <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		resc <- result{string(b), err}
+	}()
+	<-started
+
+	shutdown(t, a, errc)
+	if res := <-resc; res.err != nil || res.body != "ok" {
+		t.Fatalf("got %q, %v", res.body, res.err)
+	}
+}
@@ -0,0 +1,36 @@
+package accepter
+
+import (
+	"fmt"
+	"net"
+)
+
+// A Logger logs formatted messages. *log.Logger implements Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// connLogger is a Logger prefixing the messages with the ID and the remote address of a
+// connection.
+type connLogger struct {
+	l      Logger
+	prefix string
+}
+
+func newConnLogger(l Logger, id uint64, remoteAddr net.Addr) *connLogger {
+	return &connLogger{
+		l:      l,
+		prefix: fmt.Sprintf("conn %d %v: ", id, remoteAddr),
+	}
+}
+
+// Printf is implementation of Logger
+func (l *connLogger) Printf(format string, v ...interface{}) {
+	l.l.Printf("%s%s", l.prefix, fmt.Sprintf(format, v...))
+}
+
+// nopLogger is a Logger discarding the messages.
+type nopLogger struct{}
+
+// Printf is implementation of Logger
+func (nopLogger) Printf(format string, v ...interface{}) {}
@@ -0,0 +1,23 @@
+package accepter
+
+import (
+	"time"
+)
+
+// Metrics is the interface to instrument an Accepter, e.g. to bridge to a metrics library.
+// The methods are called concurrently, so the implementations must be safe for concurrent use.
+type Metrics interface {
+	// ConnAccepted is called when a connection is accepted, including the rejected ones.
+	ConnAccepted()
+
+	// ConnClosed is called when a served connection is closed, with the duration since
+	// the connection was tracked.
+	ConnClosed(duration time.Duration)
+
+	// AcceptError is called when accepting a connection or reading a packet fails,
+	// except the failures caused by Shutdown or Close.
+	AcceptError(err error)
+
+	// HandlerPanic is called when a panic is recovered from a handler.
+	HandlerPanic()
+}
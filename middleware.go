@@ -0,0 +1,57 @@
+package accepter
+
+import (
+	"context"
+	"log"
+	"net"
+	"time"
+)
+
+// A Middleware wraps a Handler to add behavior before and after it.
+type Middleware func(Handler) Handler
+
+// Chain wraps h with the Middlewares mws. The first Middleware is the outermost one,
+// so the Middlewares run in the given order.
+func Chain(h Handler, mws ...Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// RecoverMiddleware returns a Middleware that recovers panics from the wrapped Handler,
+// and calls f with the connection and the recovered value. If f is nil, the recovered
+// value is discarded.
+func RecoverMiddleware(f func(conn net.Conn, recovered interface{})) Middleware {
+	return func(h Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, conn net.Conn) {
+			defer func() {
+				if e := recover(); e != nil && f != nil {
+					f(conn, e)
+				}
+			}()
+			h.Serve(ctx, conn)
+		})
+	}
+}
+
+// LogMiddleware returns a Middleware that logs the start and the end of serving each
+// connection with its duration to logger. If logger is nil, the log package's standard
+// logger is used.
+func LogMiddleware(logger *log.Logger) Middleware {
+	logf := log.Printf
+	if logger != nil {
+		logf = logger.Printf
+	}
+	return func(h Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, conn net.Conn) {
+			start := time.Now()
+			remoteAddr := conn.RemoteAddr()
+			logf("accepter: serving %v", remoteAddr)
+			defer func() {
+				logf("accepter: served %v in %v", remoteAddr, time.Since(start))
+			}()
+			h.Serve(ctx, conn)
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package accepter
+
+import (
+	"context"
+	"net"
+)
+
+// defaultPeekSize is the default value of ProtocolMux.PeekSize.
+const defaultPeekSize = 16
+
+// A ProtocolMux is a Handler that dispatches connections to Handlers by matching the first
+// bytes of the incoming data, e.g. to serve TLS and plaintext on the same listener. The
+// Handlers get the connection with the peeked bytes unconsumed. If the connection doesn't
+// implement PeekableConn, it's wrapped to implement it.
+//
+// A ProtocolMux must be configured before serving.
+type ProtocolMux struct {
+	// PeekSize is the maximum number of bytes given to the matchers. Zero or negative
+	// values mean the default of 16.
+	PeekSize int
+
+	// Default is the Handler for the connections that don't match any matcher.
+	// If nil, such connections are closed.
+	Default Handler
+
+	routes []protocolRoute
+}
+
+type protocolRoute struct {
+	match   func([]byte) bool
+	handler Handler
+}
+
+// Handle registers the Handler h for the connections whose first bytes match by match. The
+// matchers are tried in the order of registration. A matcher may get fewer bytes than
+// PeekSize, since only the bytes that have already arrived after the first one are given.
+func (m *ProtocolMux) Handle(match func([]byte) bool, h Handler) {
+	m.routes = append(m.routes, protocolRoute{
+		match:   match,
+		handler: h,
+	})
+}
+
+// Serve is implementation of Handler
+func (m *ProtocolMux) Serve(ctx context.Context, conn net.Conn) {
+	pc, ok := conn.(PeekableConn)
+	if !ok {
+		pc = newPeekConn(conn)
+	}
+
+	if _, err := pc.Peek(1); err != nil {
+		return
+	}
+	n := m.PeekSize
+	if n <= 0 {
+		n = defaultPeekSize
+	}
+	if buffered := pc.Buffered(); n > buffered {
+		n = buffered
+	}
+	b, err := pc.Peek(n)
+	if err != nil {
+		return
+	}
+
+	for _, r := range m.routes {
+		if r.match(b) {
+			r.handler.Serve(ctx, pc)
+			return
+		}
+	}
+	if m.Default != nil {
+		m.Default.Serve(ctx, pc)
+	}
+}
+
+// MatchTLS is a matcher for ProtocolMux, which matches the TLS handshake record.
+func MatchTLS(b []byte) bool {
+	return len(b) > 0 && b[0] == 0x16
+}
@@ -0,0 +1,86 @@
+package accepter
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"time"
+)
+
+// An Option configures an Accepter created by NewAccepter.
+type Option func(a *Accepter) error
+
+// NewAccepter creates a new Accepter with the Handler h, and applies the Options opts in order.
+// It returns an error wrapping ErrInvalidOption if an Option is invalid. Creating an Accepter
+// by a struct literal is still valid.
+func NewAccepter(h Handler, opts ...Option) (*Accepter, error) {
+	a := &Accepter{
+		Handler: h,
+	}
+	for _, opt := range opts {
+		if err := opt(a); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// WithTLSConfig returns an Option that sets TLSConfig.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(a *Accepter) error {
+		a.TLSConfig = config
+		return nil
+	}
+}
+
+// WithIdleTimeout returns an Option that sets IdleTimeout. The timeout must not be negative.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(a *Accepter) error {
+		if d < 0 {
+			return fmt.Errorf("%w: negative idle timeout %v", ErrInvalidOption, d)
+		}
+		a.IdleTimeout = d
+		return nil
+	}
+}
+
+// WithReadTimeout returns an Option that sets ReadTimeout. The timeout must not be negative.
+func WithReadTimeout(d time.Duration) Option {
+	return func(a *Accepter) error {
+		if d < 0 {
+			return fmt.Errorf("%w: negative read timeout %v", ErrInvalidOption, d)
+		}
+		a.ReadTimeout = d
+		return nil
+	}
+}
+
+// WithWriteTimeout returns an Option that sets WriteTimeout. The timeout must not be negative.
+func WithWriteTimeout(d time.Duration) Option {
+	return func(a *Accepter) error {
+		if d < 0 {
+			return fmt.Errorf("%w: negative write timeout %v", ErrInvalidOption, d)
+		}
+		a.WriteTimeout = d
+		return nil
+	}
+}
+
+// WithMaxConnections returns an Option that sets MaxConnections. The limit must not be negative.
+func WithMaxConnections(n int) Option {
+	return func(a *Accepter) error {
+		if n < 0 {
+			return fmt.Errorf("%w: negative max connections %d", ErrInvalidOption, n)
+		}
+		a.MaxConnections = n
+		return nil
+	}
+}
+
+// WithErrorLog returns an Option that sets ErrorLog.
+func WithErrorLog(logger *log.Logger) Option {
+	return func(a *Accepter) error {
+		a.ErrorLog = logger
+		return nil
+	}
+}
@@ -0,0 +1,150 @@
+package accepter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// maxPacketSize is the maximum size of a UDP datagram.
+const maxPacketSize = 64 * 1024
+
+// UDPListenAndServe listens on the given UDP address; and then calls
+// ServePacket to handle incoming packets. UDPListenAndServe returns
+// ErrServerClosed after Close or Shutdown method called.
+func (a *Accepter) UDPListenAndServe(address string) error {
+	pc, err := a.listenConfig().ListenPacket(context.Background(), "udp", address)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	return a.ServePacket(pc)
+}
+
+// ServePacket reads incoming packets on the PacketConn pc, creating a new service
+// goroutine for each. The service goroutines call a.PacketHandler to reply to
+// them. ServePacket always closes pc unless returned error is ErrAlreadyServed.
+// ServePacket returns ErrServerClosed after Close or Shutdown method called.
+//
+// Shutdown waits for the service goroutines to return. Since there are no
+// connections to close, Close doesn't interrupt them. If BaseContext is set, it's
+// called with a nil Listener. PanicHandler is called with a nil connection.
+func (a *Accepter) ServePacket(pc net.PacketConn) (err error) {
+	baseCtx := context.Background()
+	if a.BaseContext != nil {
+		baseCtx = a.BaseContext(nil)
+		if baseCtx == nil {
+			panic("BaseContext returned a nil context")
+		}
+	}
+
+	if err = a.start(baseCtx, nil, nil, pc); err != nil {
+		if err != ErrAlreadyServed {
+			pc.Close()
+		}
+		return
+	}
+
+	defer a.stop()
+	defer a.cancel()
+
+	buf := make([]byte, maxPacketSize)
+	td := a.newTempDelay()
+	temps := 0
+	defer func() {
+		if err != nil && err != ErrServerClosed {
+			err = fmt.Errorf("read on %v failed after %d temporary errors: %w", pc.LocalAddr(), temps, err)
+		}
+	}()
+	for {
+		var n int
+		var addr net.Addr
+		n, addr, err = pc.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-a.ctx.Done():
+				err = ErrServerClosed
+				return
+			default:
+			}
+			if a.closing.Load() {
+				err = ErrServerClosed
+				return
+			}
+			a.stats.acceptErrors.Add(1)
+			if a.Metrics != nil {
+				a.Metrics.AcceptError(err)
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if retriable, exhausted := classifyError(err); retriable {
+				delay, ok := td.next(exhausted)
+				if !ok {
+					a.logf("accepter: read error: %v; max temporary delay %v exceeded", err, delay)
+					return
+				}
+				if exhausted {
+					a.logf("accepter: read error: %v; file descriptors exhausted, retrying in %v", err, delay)
+				} else {
+					a.logf("accepter: read error: %v; retrying in %v", err, delay)
+				}
+				temps++
+				if !sleep(a.ctx, delay) {
+					err = ErrServerClosed
+					return
+				}
+				continue
+			}
+			a.logf("accepter: read error: %v", err)
+			return
+		}
+		td.reset()
+		a.stats.totalPackets.Add(1)
+		if !a.trackPacket() {
+			err = ErrServerClosed
+			return
+		}
+		data := make([]byte, n)
+		copy(data, buf[:n])
+		go a.servePacket(pc, addr, data)
+	}
+}
+
+// trackPacket counts a packet in flight. It returns false without counting if the
+// Accepter has been cancelled.
+func (a *Accepter) trackPacket() bool {
+	a.connsMu.Lock()
+	defer a.connsMu.Unlock()
+	if a.ctx.Err() != nil {
+		return false
+	}
+	a.packets++
+	return true
+}
+
+// untrackPacket uncounts a packet in flight, and signals waiting Shutdown calls
+// when nothing remains.
+func (a *Accepter) untrackPacket() {
+	a.connsMu.Lock()
+	defer a.connsMu.Unlock()
+	a.packets--
+	a.signalDrained()
+}
+
+func (a *Accepter) servePacket(pc net.PacketConn, addr net.Addr, data []byte) {
+	defer func() {
+		if e := recover(); e != nil {
+			a.countPanic()
+			if a.PanicHandler != nil {
+				a.PanicHandler(nil, e)
+			} else {
+				a.logf("accepter: panic serving packet from %v: %v\n%s", addr, e, stack())
+			}
+		}
+		a.untrackPacket()
+	}()
+
+	a.PacketHandler.ServePacket(a.ctx, pc, addr, data)
+}
@@ -0,0 +1,63 @@
+package accepter
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+type testContextKey struct{}
+
+func TestServePacketPanic(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := make(chan interface{}, 1)
+	recovered := make(chan interface{}, 1)
+	a := &Accepter{
+		BaseContext: func(lis net.Listener) context.Context {
+			return context.WithValue(context.Background(), testContextKey{}, "base")
+		},
+		PanicHandler: func(conn net.Conn, e interface{}) {
+			if conn != nil {
+				t.Errorf("PanicHandler is called with %v", conn)
+			}
+			recovered <- e
+		},
+		PacketHandler: PacketHandlerFunc(func(ctx context.Context, pc net.PacketConn, addr net.Addr, data []byte) {
+			value <- ctx.Value(testContextKey{})
+			panic("packet")
+		}),
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- a.ServePacket(pc)
+	}()
+
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case v := <-value:
+		if v != "base" {
+			t.Errorf("context value is %v", v)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("packet isn't handled")
+	}
+	if e := <-recovered; e != "packet" {
+		t.Errorf("PanicHandler is called with %v", e)
+	}
+
+	shutdown(t, a, errc)
+	if s := a.Stats(); s.HandlerPanics != 1 || s.TotalPackets != 1 {
+		t.Errorf("Stats returned %+v", s)
+	}
+}
@@ -0,0 +1,92 @@
+package accepter
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// Pipe returns an in-memory Listener and a function to dial it, e.g. to test handlers without
+// real sockets. Each dial creates a connection pair by net.Pipe, and the Listener accepts the
+// server side while dial returns the client side. The Listener can be served by Serve like any
+// other Listener, and closing it by Shutdown or Close makes the pending and later dials fail
+// with net.ErrClosed. Since net.Pipe is synchronous and unbuffered, a write blocks until the
+// other side reads it.
+func Pipe() (lis net.Listener, dial func(ctx context.Context) (net.Conn, error)) {
+	l := &pipeListener{
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+	return l, l.dial
+}
+
+// pipeListener is the Listener returned by Pipe.
+type pipeListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+// Accept is implementation of net.Listener
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, l.opError("accept", net.ErrClosed)
+	}
+}
+
+// Close is implementation of net.Listener
+func (l *pipeListener) Close() error {
+	err := l.opError("close", net.ErrClosed)
+	l.once.Do(func() {
+		close(l.done)
+		err = nil
+	})
+	return err
+}
+
+// Addr is implementation of net.Listener
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+func (l *pipeListener) dial(ctx context.Context) (net.Conn, error) {
+	server, client := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		err := l.opError("dial", net.ErrClosed)
+		server.Close()
+		client.Close()
+		return nil, err
+	case <-ctx.Done():
+		server.Close()
+		client.Close()
+		return nil, l.opError("dial", ctx.Err())
+	}
+}
+
+func (l *pipeListener) opError(op string, err error) error {
+	return &net.OpError{
+		Op:   op,
+		Net:  "pipe",
+		Addr: l.Addr(),
+		Err:  err,
+	}
+}
+
+// pipeAddr is the address of the Listener returned by Pipe.
+type pipeAddr struct{}
+
+// Network is implementation of net.Addr
+func (pipeAddr) Network() string {
+	return "pipe"
+}
+
+// String is implementation of net.Addr
+func (pipeAddr) String() string {
+	return "pipe"
+}
@@ -0,0 +1,235 @@
+package accepter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+const (
+	// proxyV1MaxLen is the maximum length of a PROXY protocol v1 header including CRLF.
+	proxyV1MaxLen = 107
+)
+
+// proxyListener wraps net.Listener to parse PROXY protocol headers of the accepted connections.
+type proxyListener struct {
+	net.Listener
+}
+
+// Accept is implementation of net.Listener
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return newProxyConn(conn), nil
+}
+
+// proxyConn wraps net.Conn to parse the PROXY protocol header at the start of the connection.
+// The header is parsed lazily on the first call of Read, RemoteAddr or LocalAddr. If the header
+// is malformed, the connection is closed and Read returns ErrInvalidProxyHeader.
+type proxyConn struct {
+	net.Conn
+	r          *bufio.Reader
+	once       sync.Once
+	err        error
+	remoteAddr net.Addr
+	localAddr  net.Addr
+}
+
+func newProxyConn(conn net.Conn) *proxyConn {
+	return &proxyConn{
+		Conn: conn,
+		r:    bufio.NewReader(conn),
+	}
+}
+
+func (c *proxyConn) init() error {
+	c.once.Do(func() {
+		c.remoteAddr, c.localAddr, c.err = readProxyHeader(c.r)
+		if c.err != nil {
+			c.Conn.Close()
+		}
+	})
+	return c.err
+}
+
+// Read is implementation of net.Conn
+func (c *proxyConn) Read(b []byte) (n int, err error) {
+	if err = c.init(); err != nil {
+		return
+	}
+	return c.r.Read(b)
+}
+
+// ReadFrom is implementation of io.ReaderFrom
+func (c *proxyConn) ReadFrom(r io.Reader) (n int64, err error) {
+	return readFrom(c.Conn, r)
+}
+
+// WriteTo is implementation of io.WriterTo
+func (c *proxyConn) WriteTo(w io.Writer) (n int64, err error) {
+	if err = c.init(); err != nil {
+		return
+	}
+	return c.r.WriteTo(w)
+}
+
+// RemoteAddr is implementation of net.Conn. It returns the source address in the
+// PROXY protocol header if present.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.init() == nil && c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr is implementation of net.Conn. It returns the destination address in the
+// PROXY protocol header if present.
+func (c *proxyConn) LocalAddr() net.Addr {
+	if c.init() == nil && c.localAddr != nil {
+		return c.localAddr
+	}
+	return c.Conn.LocalAddr()
+}
+
+// readProxyHeader reads a PROXY protocol v1 or v2 header from r. It returns nil addresses
+// without error if the header doesn't carry any address.
+func readProxyHeader(r *bufio.Reader) (src, dst net.Addr, err error) {
+	b, err := r.Peek(len(proxyV1Prefix))
+	if err != nil {
+		return nil, nil, ErrInvalidProxyHeader
+	}
+	if bytes.Equal(b, proxyV1Prefix) {
+		return readProxyV1Header(r)
+	}
+	b, err = r.Peek(len(proxyV2Signature))
+	if err != nil || !bytes.Equal(b, proxyV2Signature) {
+		return nil, nil, ErrInvalidProxyHeader
+	}
+	return readProxyV2Header(r)
+}
+
+func readProxyV1Header(r *bufio.Reader) (src, dst net.Addr, err error) {
+	var line []byte
+	for len(line) < proxyV1MaxLen {
+		var c byte
+		c, err = r.ReadByte()
+		if err != nil {
+			return nil, nil, ErrInvalidProxyHeader
+		}
+		line = append(line, c)
+		if c == '\n' {
+			break
+		}
+	}
+	s := string(line)
+	if !strings.HasSuffix(s, "\r\n") {
+		return nil, nil, ErrInvalidProxyHeader
+	}
+	fields := strings.Split(strings.TrimSuffix(s, "\r\n"), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, ErrInvalidProxyHeader
+	}
+	is4 := fields[1] == "TCP4"
+	srcIP, ok1 := parseProxyV1IP(fields[2], is4)
+	dstIP, ok2 := parseProxyV1IP(fields[3], is4)
+	if !ok1 || !ok2 {
+		return nil, nil, ErrInvalidProxyHeader
+	}
+	srcPort, err1 := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, err2 := strconv.ParseUint(fields[5], 10, 16)
+	if err1 != nil || err2 != nil {
+		return nil, nil, ErrInvalidProxyHeader
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(srcIP, uint16(srcPort))),
+		net.TCPAddrFromAddrPort(netip.AddrPortFrom(dstIP, uint16(dstPort))), nil
+}
+
+// parseProxyV1IP parses the textual IP address s of a PROXY protocol v1 header. s must be
+// an IPv4 address if is4, otherwise an IPv6 address, which may be IPv4-mapped.
+func parseProxyV1IP(s string, is4 bool) (netip.Addr, bool) {
+	ip, err := netip.ParseAddr(s)
+	if err != nil || ip.Zone() != "" || ip.Is4() != is4 {
+		return netip.Addr{}, false
+	}
+	return ip, true
+}
+
+func readProxyV2Header(r *bufio.Reader) (src, dst net.Addr, err error) {
+	var hdr [16]byte
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		return nil, nil, ErrInvalidProxyHeader
+	}
+	verCmd, fam := hdr[12], hdr[13]
+	if verCmd>>4 != 2 {
+		return nil, nil, ErrInvalidProxyHeader
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return nil, nil, ErrInvalidProxyHeader
+	}
+	switch verCmd & 0x0f {
+	case 0x0:
+		// LOCAL command: the connection was established by the proxy itself.
+		return nil, nil, nil
+	case 0x1:
+	default:
+		return nil, nil, ErrInvalidProxyHeader
+	}
+	var ipLen int
+	switch fam >> 4 {
+	case 0x0:
+		return nil, nil, nil
+	case 0x1:
+		ipLen = net.IPv4len
+	case 0x2:
+		ipLen = net.IPv6len
+	case 0x3:
+		const pathLen = 108
+		if len(payload) < 2*pathLen {
+			return nil, nil, ErrInvalidProxyHeader
+		}
+		return &net.UnixAddr{Name: cString(payload[:pathLen]), Net: "unix"},
+			&net.UnixAddr{Name: cString(payload[pathLen : 2*pathLen]), Net: "unix"}, nil
+	default:
+		return nil, nil, ErrInvalidProxyHeader
+	}
+	if len(payload) < 2*ipLen+4 {
+		return nil, nil, ErrInvalidProxyHeader
+	}
+	srcIP := net.IP(append([]byte(nil), payload[:ipLen]...))
+	dstIP := net.IP(append([]byte(nil), payload[ipLen:2*ipLen]...))
+	srcPort := int(binary.BigEndian.Uint16(payload[2*ipLen:]))
+	dstPort := int(binary.BigEndian.Uint16(payload[2*ipLen+2:]))
+	switch fam & 0x0f {
+	case 0x1:
+		return &net.TCPAddr{IP: srcIP, Port: srcPort}, &net.TCPAddr{IP: dstIP, Port: dstPort}, nil
+	case 0x2:
+		return &net.UDPAddr{IP: srcIP, Port: srcPort}, &net.UDPAddr{IP: dstIP, Port: dstPort}, nil
+	default:
+		return nil, nil, ErrInvalidProxyHeader
+	}
+}
+
+// cString returns the string in b up to the first NUL byte.
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
@@ -0,0 +1,76 @@
+package accepter
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+// proxyV2Header returns a PROXY protocol v2 header with the given version and command,
+// address family and protocol, and payload.
+func proxyV2Header(verCmd, fam byte, payload []byte) string {
+	b := append([]byte(nil), proxyV2Signature...)
+	b = append(b, verCmd, fam)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(payload)))
+	return string(append(b, payload...))
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	ipv4Payload := []byte{10, 0, 0, 1, 10, 0, 0, 2, 0x30, 0x39, 0x00, 0x50}
+	ipv6Payload := append(append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...),
+		0x30, 0x39, 0x00, 0x50)
+	for _, tt := range []struct {
+		name     string
+		header   string
+		src, dst string
+		err      error
+	}{
+		{"TCP4", "PROXY TCP4 10.0.0.1 10.0.0.2 12345 80\r\n", "10.0.0.1:12345", "10.0.0.2:80", nil},
+		{"TCP6", "PROXY TCP6 2001:db8::1 2001:db8::2 12345 80\r\n", "[2001:db8::1]:12345", "[2001:db8::2]:80", nil},
+		{"TCP6Mapped", "PROXY TCP6 ::ffff:10.0.0.1 ::ffff:10.0.0.2 12345 80\r\n", "10.0.0.1:12345", "10.0.0.2:80", nil},
+		{"UNKNOWN", "PROXY UNKNOWN\r\n", "", "", nil},
+		{"UNKNOWNAddrs", "PROXY UNKNOWN ffff:f...f ffff:f...f 65535 65535\r\n", "", "", nil},
+		{"TCP4WithIPv6", "PROXY TCP4 2001:db8::1 10.0.0.2 12345 80\r\n", "", "", ErrInvalidProxyHeader},
+		{"TCP6WithIPv4", "PROXY TCP6 10.0.0.1 2001:db8::2 12345 80\r\n", "", "", ErrInvalidProxyHeader},
+		{"BadPort", "PROXY TCP4 10.0.0.1 10.0.0.2 65536 80\r\n", "", "", ErrInvalidProxyHeader},
+		{"BadFields", "PROXY TCP4 10.0.0.1 10.0.0.2 12345\r\n", "", "", ErrInvalidProxyHeader},
+		{"NoCRLF", "PROXY TCP4 10.0.0.1 10.0.0.2 12345 80\n", "", "", ErrInvalidProxyHeader},
+		{"V1Truncated", "PROXY TCP4 10.0.0.1", "", "", ErrInvalidProxyHeader},
+		{"V1TooLong", "PROXY UNKNOWN " + strings.Repeat("a", proxyV1MaxLen) + "\r\n", "", "", ErrInvalidProxyHeader},
+		{"NoHeader", "GET / HTTP/1.1\r\n\r\n", "", "", ErrInvalidProxyHeader},
+		{"V2ProxyTCP4", proxyV2Header(0x21, 0x11, ipv4Payload), "10.0.0.1:12345", "10.0.0.2:80", nil},
+		{"V2ProxyTCP6", proxyV2Header(0x21, 0x21, ipv6Payload), "[2001:db8::1]:12345", "[2001:db8::2]:80", nil},
+		{"V2ProxyUDP4", proxyV2Header(0x21, 0x12, ipv4Payload), "10.0.0.1:12345", "10.0.0.2:80", nil},
+		{"V2Local", proxyV2Header(0x20, 0x00, nil), "", "", nil},
+		{"V2LocalWithAddrs", proxyV2Header(0x20, 0x11, ipv4Payload), "", "", nil},
+		{"V2Unspec", proxyV2Header(0x21, 0x00, nil), "", "", nil},
+		{"V2BadVersion", proxyV2Header(0x11, 0x11, ipv4Payload), "", "", ErrInvalidProxyHeader},
+		{"V2BadCommand", proxyV2Header(0x22, 0x11, ipv4Payload), "", "", ErrInvalidProxyHeader},
+		{"V2ShortAddrs", proxyV2Header(0x21, 0x21, ipv4Payload), "", "", ErrInvalidProxyHeader},
+		{"V2TruncatedHeader", proxyV2Header(0x21, 0x11, ipv4Payload)[:14], "", "", ErrInvalidProxyHeader},
+		{"V2TruncatedPayload", proxyV2Header(0x21, 0x11, ipv4Payload)[:20], "", "", ErrInvalidProxyHeader},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst, err := readProxyHeader(bufio.NewReader(strings.NewReader(tt.header)))
+			if err != tt.err {
+				t.Fatalf("readProxyHeader returned error %v, want %v", err, tt.err)
+			}
+			if got := addrString(src); got != tt.src {
+				t.Errorf("source address is %q, want %q", got, tt.src)
+			}
+			if got := addrString(dst); got != tt.dst {
+				t.Errorf("destination address is %q, want %q", got, tt.dst)
+			}
+		})
+	}
+}
+
+// addrString returns the string of addr, or an empty string if addr is nil.
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
@@ -0,0 +1,131 @@
+package accepter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A RateLimiter is a token bucket limiting the rate of events. The bucket is initially
+// full with burst tokens, and it's refilled at limit tokens per second. Each event
+// consumes one token. A RateLimiter is safe for concurrent use, and it can be
+// reconfigured at runtime.
+type RateLimiter struct {
+	mu     sync.Mutex
+	limit  float64
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a new RateLimiter that allows events up to rate limit per second
+// and permits bursts of at most burst events. Zero or negative limit means unlimited.
+// Burst values less than 1 are treated as 1.
+func NewRateLimiter(limit float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		limit:  limit,
+		burst:  burst,
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Limit returns the rate limit per second.
+func (l *RateLimiter) Limit() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// SetLimit sets the rate limit per second. Zero or negative limit means unlimited.
+func (l *RateLimiter) SetLimit(limit float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.advance(time.Now())
+	l.limit = limit
+}
+
+// Burst returns the maximum burst size.
+func (l *RateLimiter) Burst() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.burst
+}
+
+// SetBurst sets the maximum burst size. Values less than 1 are treated as 1.
+func (l *RateLimiter) SetBurst(burst int) {
+	if burst < 1 {
+		burst = 1
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.advance(time.Now())
+	l.burst = burst
+	if l.tokens > float64(burst) {
+		l.tokens = float64(burst)
+	}
+}
+
+// Allow reports whether an event may happen now, and consumes a token if so.
+func (l *RateLimiter) Allow() bool {
+	_, ok := l.take(1)
+	return ok
+}
+
+// Wait blocks until an event may happen, and consumes a token. It returns the context
+// error if ctx is done before.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	return l.WaitN(ctx, 1)
+}
+
+// WaitN blocks until n events may happen, and consumes n tokens. It returns the context
+// error if ctx is done before. n is reduced to the burst size if it's greater, so that
+// WaitN doesn't block forever.
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	for {
+		d, ok := l.take(n)
+		if ok {
+			return nil
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// take consumes n tokens if available. Otherwise it returns the duration until the tokens
+// become available.
+func (l *RateLimiter) take(n int) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limit <= 0 {
+		return 0, true
+	}
+	if n > l.burst {
+		n = l.burst
+	}
+	l.advance(time.Now())
+	if l.tokens >= float64(n) {
+		l.tokens -= float64(n)
+		return 0, true
+	}
+	return time.Duration((float64(n) - l.tokens) / l.limit * float64(time.Second)), false
+}
+
+// advance refills the bucket up to now. It must be called with l.mu locked.
+func (l *RateLimiter) advance(now time.Time) {
+	if elapsed := now.Sub(l.last); elapsed > 0 && l.limit > 0 {
+		l.tokens += elapsed.Seconds() * l.limit
+		if l.tokens > float64(l.burst) {
+			l.tokens = float64(l.burst)
+		}
+	}
+	l.last = now
+}
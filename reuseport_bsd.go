@@ -0,0 +1,11 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package accepter
+
+import (
+	"syscall"
+)
+
+// soReusePort is the value of SO_REUSEPORT.
+const soReusePort = syscall.SO_REUSEPORT
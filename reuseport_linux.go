@@ -0,0 +1,7 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le
+// +build linux,!mips,!mipsle,!mips64,!mips64le
+
+package accepter
+
+// soReusePort is the value of SO_REUSEPORT, which isn't defined by the syscall package on all architectures.
+const soReusePort = 0xf
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package accepter
+
+import (
+	"syscall"
+)
+
+// reusePortControl is a control function of net.ListenConfig, which fails since SO_REUSEPORT
+// is unsupported on this platform.
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return ErrReusePortUnsupported
+}
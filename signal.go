@@ -0,0 +1,26 @@
+package accepter
+
+import (
+	"context"
+	"os"
+	"os/signal"
+)
+
+// ListenAndServeWithSignals listens on the given network and address; and then calls
+// Serve to handle incoming connections until one of the signals sig is received.
+// If sig is empty, it waits for SIGINT and SIGTERM. On a signal, it calls Shutdown
+// limited by ShutdownTimeout, and returns the error of Shutdown, e.g. wrapping
+// ErrShutdownTimeout if the connections couldn't be drained in time.
+// Otherwise it returns the error of Serve.
+func (a *Accepter) ListenAndServeWithSignals(network, address string, sig ...os.Signal) error {
+	if len(sig) == 0 {
+		sig = shutdownSignals
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), sig...)
+	defer stop()
+	err := a.ListenAndServeContext(ctx, network, address)
+	if err == ErrServerClosed && ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
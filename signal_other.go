@@ -0,0 +1,12 @@
+//go:build !plan9
+// +build !plan9
+
+package accepter
+
+import (
+	"os"
+	"syscall"
+)
+
+// shutdownSignals are the default signals of ListenAndServeWithSignals.
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
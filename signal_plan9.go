@@ -0,0 +1,8 @@
+package accepter
+
+import (
+	"os"
+)
+
+// shutdownSignals are the default signals of ListenAndServeWithSignals.
+var shutdownSignals = []os.Signal{os.Interrupt}
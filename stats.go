@@ -0,0 +1,82 @@
+package accepter
+
+import (
+	"sync/atomic"
+)
+
+// Stats is a snapshot of the connection statistics of an Accepter.
+type Stats struct {
+	// ActiveConns is the number of connections currently being served.
+	ActiveConns int
+
+	// TotalAccepted is the number of connections accepted during the lifetime of the
+	// Accepter, including the rejected ones.
+	TotalAccepted uint64
+
+	// TotalClosed is the number of served connections closed during the lifetime of the
+	// Accepter. The hijacked connections aren't counted.
+	TotalClosed uint64
+
+	// TotalHijacked is the number of connections hijacked during the lifetime of the
+	// Accepter.
+	TotalHijacked uint64
+
+	// TotalPackets is the number of packets read by ServePacket during the lifetime of
+	// the Accepter.
+	TotalPackets uint64
+
+	// AcceptErrors is the number of errors of accepting connections or reading packets,
+	// except the ones caused by Shutdown or Close.
+	AcceptErrors uint64
+
+	// HandlerPanics is the number of panics recovered from handlers.
+	HandlerPanics uint64
+
+	// BytesRead is the number of bytes read from connections. It's counted only if
+	// CountBytes is set.
+	BytesRead uint64
+
+	// BytesWritten is the number of bytes written to connections. It's counted only if
+	// CountBytes is set.
+	BytesWritten uint64
+}
+
+// stats holds the counters of Stats, which are maintained atomically.
+type stats struct {
+	totalAccepted atomic.Uint64
+	totalClosed   atomic.Uint64
+	totalHijacked atomic.Uint64
+	totalPackets  atomic.Uint64
+	acceptErrors  atomic.Uint64
+	handlerPanics atomic.Uint64
+	bytesRead     atomic.Uint64
+	bytesWritten  atomic.Uint64
+}
+
+// Stats returns a snapshot of the connection statistics. It's safe to call concurrently.
+func (a *Accepter) Stats() Stats {
+	return Stats{
+		ActiveConns:   a.ActiveConns(),
+		TotalAccepted: a.stats.totalAccepted.Load(),
+		TotalClosed:   a.stats.totalClosed.Load(),
+		TotalHijacked: a.stats.totalHijacked.Load(),
+		TotalPackets:  a.stats.totalPackets.Load(),
+		AcceptErrors:  a.stats.acceptErrors.Load(),
+		HandlerPanics: a.stats.handlerPanics.Load(),
+		BytesRead:     a.stats.bytesRead.Load(),
+		BytesWritten:  a.stats.bytesWritten.Load(),
+	}
+}
+
+// ActiveConns returns the number of connections currently being served.
+func (a *Accepter) ActiveConns() int {
+	a.connsMu.RLock()
+	defer a.connsMu.RUnlock()
+	return len(a.conns)
+}
+
+// TotalAccepted returns the number of connections accepted during the lifetime of the Accepter,
+// including the rejected ones.
+func (a *Accepter) TotalAccepted() uint64 {
+	return a.stats.totalAccepted.Load()
+}
@@ -0,0 +1,84 @@
+package accepter
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"syscall"
+	"testing"
+)
+
+// serveSockopt serves a by Serve, or by ServeTLS if tlsOn, and returns the value of f
+// called with the file descriptor of the TCP connection given to the handler.
+func serveSockopt(t *testing.T, a *Accepter, tlsOn bool, f func(fd int) (int, error)) int {
+	t.Helper()
+	result := make(chan int, 1)
+	a.Handler = HandlerFunc(func(ctx context.Context, conn net.Conn) {
+		if tc, ok := conn.(*tls.Conn); ok {
+			conn = tc.NetConn()
+		}
+		rc, err := conn.(*net.TCPConn).SyscallConn()
+		if err != nil {
+			t.Error(err)
+			result <- -1
+			return
+		}
+		v := -1
+		rc.Control(func(fd uintptr) {
+			if v, err = f(int(fd)); err != nil {
+				t.Error(err)
+			}
+		})
+		result <- v
+	})
+
+	var (
+		addr string
+		errc <-chan error
+	)
+	if tlsOn {
+		cert, _, _ := testCert(t)
+		a.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		addr, errc = serveTLS(t, a, "", "")
+		conn := dialTLS(t, addr, &tls.Config{InsecureSkipVerify: true})
+		defer conn.Close()
+	} else {
+		addr, errc = serveTCP(t, a)
+		conn := dial(t, addr)
+		defer conn.Close()
+	}
+	v := <-result
+	shutdown(t, a, errc)
+	return v
+}
+
+func TestNoDelay(t *testing.T) {
+	for _, tlsOn := range []bool{false, true} {
+		noDelay := false
+		a := &Accepter{
+			NoDelay: &noDelay,
+		}
+		v := serveSockopt(t, a, tlsOn, func(fd int) (int, error) {
+			return syscall.GetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+		})
+		if v != 0 {
+			t.Errorf("TCP_NODELAY is %d with TLS %v", v, tlsOn)
+		}
+	}
+}
+
+func TestLinger(t *testing.T) {
+	for _, tlsOn := range []bool{false, true} {
+		linger := 3
+		a := &Accepter{
+			Linger: &linger,
+		}
+		// the option value is truncated to l_onoff of struct linger
+		v := serveSockopt(t, a, tlsOn, func(fd int) (int, error) {
+			return syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_LINGER)
+		})
+		if v == 0 {
+			t.Errorf("SO_LINGER isn't enabled with TLS %v", tlsOn)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+//go:build !plan9
+// +build !plan9
+
+package accepter
+
+import (
+	"errors"
+	"syscall"
+)
+
+// retriableErrs are the errors of accepting or reading, which are worth retrying after a delay.
+var retriableErrs = []error{
+	syscall.EMFILE,
+	syscall.ENFILE,
+	syscall.ENOBUFS,
+	syscall.ENOMEM,
+	syscall.ECONNABORTED,
+	syscall.ECONNRESET,
+}
+
+// classifyError reports whether err is worth retrying after a delay, and whether it's caused by
+// exhaustion of file descriptors.
+func classifyError(err error) (retriable, exhausted bool) {
+	if errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) {
+		return true, true
+	}
+	for _, e := range retriableErrs {
+		if errors.Is(err, e) {
+			return true, false
+		}
+	}
+	return false, false
+}
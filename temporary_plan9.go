@@ -0,0 +1,15 @@
+package accepter
+
+import (
+	"errors"
+	"syscall"
+)
+
+// classifyError reports whether err is worth retrying after a delay, and whether it's caused by
+// exhaustion of file descriptors.
+func classifyError(err error) (retriable, exhausted bool) {
+	if errors.Is(err, syscall.EMFILE) {
+		return true, true
+	}
+	return false, false
+}
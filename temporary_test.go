@@ -0,0 +1,87 @@
+//go:build !plan9
+// +build !plan9
+
+package accepter
+
+import (
+	"context"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// tempErrListener is a net.Listener whose Accept fails with a temporary error n times, and
+// then blocks until it's closed. It records the times of the accepts.
+type tempErrListener struct {
+	mu     sync.Mutex
+	n      int
+	times  []time.Time
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (l *tempErrListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	l.times = append(l.times, time.Now())
+	fail := len(l.times) <= l.n
+	l.mu.Unlock()
+	if fail {
+		return nil, &net.OpError{Op: "accept", Net: "tcp", Err: syscall.ECONNABORTED}
+	}
+	<-l.closed
+	return nil, net.ErrClosed
+}
+
+func (l *tempErrListener) Close() error {
+	l.once.Do(func() {
+		close(l.closed)
+	})
+	return nil
+}
+
+func (l *tempErrListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+func TestAcceptBackoffGrows(t *testing.T) {
+	lis := &tempErrListener{
+		n:      5,
+		closed: make(chan struct{}),
+	}
+	a := &Accepter{
+		AcceptBackoffMin: 10 * time.Millisecond,
+		AcceptBackoffMax: 50 * time.Millisecond,
+		Handler:          HandlerFunc(func(ctx context.Context, conn net.Conn) {}),
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- a.Serve(lis)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		lis.mu.Lock()
+		n := len(lis.times)
+		lis.mu.Unlock()
+		if n > lis.n {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("accepted %d times", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	shutdown(t, a, errc)
+
+	// the delays are 10ms, 20ms, 40ms, and then capped at 50ms
+	want := []time.Duration{10, 20, 40, 50, 50}
+	for i, w := range want {
+		w *= time.Millisecond
+		got := lis.times[i+1].Sub(lis.times[i])
+		if got < w || got > w+40*time.Millisecond {
+			t.Errorf("delay %d is %v, want %v", i, got, w)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package accepter
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+)
+
+// RequireClientCert configures the Accepter to require and verify client certificates on TLS
+// connections, against the CA certificates in the PEM encoded file caFile. It sets TLSConfig
+// to a clone with ClientAuth and ClientCAs set, so the original TLSConfig isn't modified.
+// The verified chains are available to handlers by TLSState. RequireClientCert must be called
+// before serving. It returns an error as TLSError if the CA certificates can't be loaded.
+func (a *Accepter) RequireClientCert(caFile string) error {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return wrapTLSError(err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return wrapTLSError(errors.New("no CA certificate found in " + caFile))
+	}
+
+	config := a.cloneTLSConfig()
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	config.ClientCAs = pool
+	a.TLSConfig = config
+	return nil
+}
+
+// UpgradeTLS upgrades the plaintext connection conn served by the Accepter to TLS as a
+// server, e.g. on STARTTLS, and returns the TLS connection after the handshake. It uses a
+// clone of TLSConfig, which must provide a certificate, and the handshake is limited by
+// TLSHandshakeTimeout and ctx. The Accepter keeps tracking the connection, so the upgraded
+// connection is still closed on Shutdown or Close, and its bytes are counted. The handler
+// should use the returned connection instead of conn afterwards, and TLSState doesn't
+// report the upgraded state. UpgradeTLS returns an error as TLSError if the handshake
+// fails.
+func (a *Accepter) UpgradeTLS(ctx context.Context, conn net.Conn) (*tls.Conn, error) {
+	config := a.cloneTLSConfig()
+	if len(config.Certificates) == 0 && config.GetCertificate == nil && config.GetConfigForClient == nil {
+		return nil, wrapTLSError(errors.New("no certificate in TLSConfig"))
+	}
+	tc := tls.Server(conn, config)
+	if err := a.handshake(ctx, tc); err != nil {
+		return nil, wrapTLSError(err)
+	}
+	return tc, nil
+}
+
+// cloneTLSConfig returns a clone of TLSConfig, or an empty configuration if TLSConfig is nil.
+func (a *Accepter) cloneTLSConfig() *tls.Config {
+	if a.TLSConfig != nil {
+		return a.TLSConfig.Clone()
+	}
+	return &tls.Config{}
+}
@@ -0,0 +1,220 @@
+package accepter
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert returns a self-signed certificate for 127.0.0.1, and the paths of its PEM encoded
+// certificate and key files.
+func testCert(t *testing.T) (cert tls.Certificate, certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err = tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// serveTLS runs ServeTLS on a new local Listener in the background, and returns the address
+// and the channel of the error returned by ServeTLS.
+func serveTLS(t *testing.T, a *Accepter, certFile, keyFile string) (string, <-chan error) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- a.ServeTLS(lis, certFile, keyFile)
+	}()
+	return lis.Addr().String(), errc
+}
+
+// dialTLS connects to addr by TLS, and completes the handshake.
+func dialTLS(t *testing.T, addr string, config *tls.Config) *tls.Conn {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestServeTLSKeepsTLSConfig(t *testing.T) {
+	_, certFile, keyFile := testCert(t)
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	a := &Accepter{
+		TLSConfig: config,
+		TLSNextProto: map[string]func(*Accepter, *tls.Conn, Handler){
+			"custom": func(*Accepter, *tls.Conn, Handler) {},
+		},
+		Handler: HandlerFunc(func(ctx context.Context, conn net.Conn) {}),
+	}
+	addr, errc := serveTLS(t, a, certFile, keyFile)
+
+	conn := dialTLS(t, addr, &tls.Config{InsecureSkipVerify: true})
+	conn.Close()
+
+	if a.TLSConfig != config {
+		t.Error("TLSConfig is replaced")
+	}
+	if len(config.Certificates) != 0 {
+		t.Errorf("TLSConfig.Certificates is modified: %d certificates", len(config.Certificates))
+	}
+	if config.NextProtos != nil {
+		t.Errorf("TLSConfig.NextProtos is modified: %q", config.NextProtos)
+	}
+
+	if err := a.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != ErrServerClosed {
+		t.Fatalf("ServeTLS returned %v", err)
+	}
+}
+
+func TestTLSNextProtoDetectClose(t *testing.T) {
+	cert, _, _ := testCert(t)
+	got := make(chan string, 1)
+	a := &Accepter{
+		TLSConfig:   &tls.Config{Certificates: []tls.Certificate{cert}},
+		DetectClose: true,
+		TLSNextProto: map[string]func(*Accepter, *tls.Conn, Handler){
+			"custom": func(a *Accepter, conn *tls.Conn, h Handler) {
+				// give a read-ahead, if any, the time to start first
+				time.Sleep(50 * time.Millisecond)
+				conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				b := make([]byte, 5)
+				n, _ := io.ReadFull(conn, b)
+				got <- string(b[:n])
+			},
+		},
+		Handler: HandlerFunc(func(ctx context.Context, conn net.Conn) {
+			t.Error("handler is invoked")
+		}),
+	}
+	addr, errc := serveTLS(t, a, "", "")
+
+	conn := dialTLS(t, addr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"custom"}})
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if s := <-got; s != "hello" {
+		t.Errorf("TLSNextProto function read %q", s)
+	}
+
+	shutdown(t, a, errc)
+}
+
+func TestServeTLSGetConfigForClient(t *testing.T) {
+	cert, _, _ := testCert(t)
+	serverName := make(chan string, 1)
+	a := &Accepter{
+		TLSConfig: &tls.Config{
+			GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+				serverName <- hello.ServerName
+				return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+			},
+		},
+		Handler: HandlerFunc(func(ctx context.Context, conn net.Conn) {
+			conn.Write([]byte("ok"))
+		}),
+	}
+	addr, errc := serveTLS(t, a, "", "")
+
+	conn := dialTLS(t, addr, &tls.Config{InsecureSkipVerify: true, ServerName: "tenant.example"})
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(conn, b); err != nil || string(b) != "ok" {
+		t.Fatalf("read %q, %v", b, err)
+	}
+	if s := <-serverName; s != "tenant.example" {
+		t.Errorf("GetConfigForClient got server name %q", s)
+	}
+
+	shutdown(t, a, errc)
+}
+
+func TestTLSNextProtoIdleTimeout(t *testing.T) {
+	cert, _, _ := testCert(t)
+	result := make(chan error, 1)
+	a := &Accepter{
+		TLSConfig:   &tls.Config{Certificates: []tls.Certificate{cert}},
+		IdleTimeout: 200 * time.Millisecond,
+		TLSNextProto: map[string]func(*Accepter, *tls.Conn, Handler){
+			"custom": func(a *Accepter, conn *tls.Conn, h Handler) {
+				b := make([]byte, 1)
+				for i := 0; i < 10; i++ {
+					if _, err := io.ReadFull(conn, b); err != nil {
+						result <- err
+						return
+					}
+				}
+				result <- nil
+			},
+		},
+		Handler: HandlerFunc(func(ctx context.Context, conn net.Conn) {
+			t.Error("handler is invoked")
+		}),
+	}
+	addr, errc := serveTLS(t, a, "", "")
+
+	conn := dialTLS(t, addr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"custom"}})
+	defer conn.Close()
+	for i := 0; i < 10; i++ {
+		time.Sleep(50 * time.Millisecond)
+		if _, err := conn.Write([]byte("a")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := <-result; err != nil {
+		t.Errorf("TLSNextProto function read failed: %v", err)
+	}
+
+	shutdown(t, a, errc)
+}
@@ -0,0 +1,36 @@
+package accepter
+
+import (
+	"context"
+	"os"
+	"runtime"
+)
+
+// UnixListenAndServe listens on the Unix domain socket at the given path; and then
+// calls Serve to handle incoming connections. If UnixSocketMode is non-zero, the
+// permissions of the socket file are set to it. The socket file is removed when
+// the listener is closed. UnixListenAndServe returns ErrServerClosed after Close
+// or Shutdown method called.
+//
+// On Linux, a path starting with "@" is an abstract socket address, in accordance with
+// the net package. An abstract socket has no socket file, so UnixSocketMode is ignored
+// and nothing is left to remove. Abstract sockets are Linux-only; on the other
+// platforms, such a path is an ordinary file name.
+func (a *Accepter) UnixListenAndServe(path string) error {
+	lis, err := a.listenConfig().Listen(context.Background(), "unix", path)
+	if err != nil {
+		return err
+	}
+	defer lis.Close()
+	if a.UnixSocketMode != 0 && !isAbstractUnix(path) {
+		if err := os.Chmod(path, a.UnixSocketMode); err != nil {
+			return err
+		}
+	}
+	return a.Serve(lis)
+}
+
+// isAbstractUnix reports whether path is an abstract Unix domain socket address.
+func isAbstractUnix(path string) bool {
+	return runtime.GOOS == "linux" && len(path) > 0 && path[0] == '@'
+}